@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/codecentric/certspotter-sd/internal/check"
+	"github.com/codecentric/certspotter-sd/internal/config"
+)
+
+// checkConfig checks the configuration files and returns the exit code, which
+// is non-zero if any file is invalid.
+func checkConfig(filenames []string) int {
+	if len(filenames) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: certspotter-sd check-config <file>...")
+		return 2
+	}
+
+	code := 0
+	for _, filename := range filenames {
+		fmt.Printf("Checking %s\n", filename)
+
+		cfg, err := config.LoadFile(filename)
+		if err != nil {
+			fmt.Printf("  FAILED: %s\n", err)
+			code = 1
+			continue
+		}
+		problems := check.Check(cfg)
+		for _, problem := range problems {
+			fmt.Printf("  FAILED: %s\n", problem)
+		}
+		if len(problems) != 0 {
+			code = 1
+			continue
+		}
+		fmt.Printf("  SUCCESS: %d domains and %d files found\n",
+			len(cfg.DomainConfigs), len(cfg.FileConfigs))
+	}
+	return code
+}
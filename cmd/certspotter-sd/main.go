@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -18,39 +25,211 @@ import (
 	"github.com/codecentric/certspotter-sd/internal/version"
 )
 
+var (
+	configReloadSuccessMetric = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "certspotter_config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful",
+		},
+	)
+	configReloadTimestampMetric = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "certspotter_config_last_reload_success_timestamp_seconds",
+			Help: "The timestamp of the last successful configuration reload",
+		},
+	)
+)
+
 type arguments struct {
-	ConfigFile string
-	LogLevel   *zapcore.Level
-	MetricPort int
+	ConfigFile      string
+	LogLevel        *zapcore.Level
+	MetricPort      int
+	WatchInterval   time.Duration
+	ShutdownTimeout time.Duration
+}
+
+// reloader reloads the configuration file into discovery.
+type reloader struct {
+	filename  string
+	discovery *discovery.Discovery
+	logger    *zap.SugaredLogger
+	mtx       sync.Mutex
+	config    *config.Config
+	sum       [sha256.Size]byte
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check-config":
+			os.Exit(checkConfig(os.Args[2:]))
+		case "config":
+			os.Exit(configCommand(os.Args[2:]))
+		}
+	}
+	os.Exit(run())
+}
+
+// run runs the service discovery until it is stopped by SIGINT or SIGTERM
+// and returns the exit code.
+func run() int {
 	args := argsparse()
 
 	logger := getlogger(*args.LogLevel)
 	defer logger.Sync()
 	sugar := logger.Sugar()
 
-	cfg, err := config.LoadFile(args.ConfigFile)
+	r := &reloader{filename: args.ConfigFile, logger: sugar}
+	cfg, err := r.load()
 	if err != nil {
-		sugar.Fatalw("can't read configuration", "err", err)
+		sugar.Errorw("can't read configuration", "err", err)
+		return 1
 	}
+	configReloadSuccessMetric.Set(1)
+	configReloadTimestampMetric.SetToCurrentTime()
 
 	discovery := discovery.NewDiscovery(
 		logger.With(zap.String("component", "discovery")),
 		cfg,
 	)
+	r.discovery = discovery
 
-	http.Handle("/metrics", promhttp.Handler())
-	go http.ListenAndServe(fmt.Sprintf(":%d", args.MetricPort), nil)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/-/reload", r.ServeHTTP)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", args.MetricPort),
+		Handler: mux,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			sugar.Errorw("serving metrics", "err", err)
+		}
+	}()
 
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	go sighandler(ctx, func(sig os.Signal) {
+		if sig == syscall.SIGHUP {
+			r.Reload()
+			return
+		}
 		sugar.Infow("stopping service discovery", "signal", sig)
 		cancel()
-		os.Exit(0)
 	})
-	discovery.Discover(ctx)
+	if args.WatchInterval > 0 {
+		go r.Watch(ctx, args.WatchInterval)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- discovery.Discover(ctx)
+	}()
+	<-ctx.Done()
+
+	shutdown, stop := context.WithTimeout(context.Background(), args.ShutdownTimeout)
+	defer stop()
+
+	select {
+	case err = <-done:
+	case <-shutdown.Done():
+		err = fmt.Errorf("final export timed out after %s", args.ShutdownTimeout)
+	}
+	if serr := server.Shutdown(shutdown); serr != nil && err == nil {
+		err = fmt.Errorf("shutting down metrics server: %w", serr)
+	}
+	if err != nil {
+		sugar.Errorw("service discovery stopped with errors", "err", err)
+		return 1
+	}
+	sugar.Infow("service discovery stopped")
+	return 0
+}
+
+// load reads and parses the configuration file and the files it includes.
+func (r *reloader) load() (*config.Config, error) {
+	cfg, err := config.LoadFile(r.filename)
+	if err == nil {
+		r.config = cfg
+	}
+	r.sum = r.checksum()
+	return cfg, err
+}
+
+// checksum returns the checksum of the content of the configuration file and
+// all files included by the last loaded configuration.
+func (r *reloader) checksum() [sha256.Size]byte {
+	sources := []string{r.filename}
+	if r.config != nil {
+		sources = r.config.Sources()
+	}
+
+	h := sha256.New()
+	for _, filename := range sources {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", filename, len(data))
+		h.Write(data)
+	}
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// Reload reloads the configuration file. The running configuration is kept
+// if it can't be loaded.
+func (r *reloader) Reload() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	cfg, err := r.load()
+	if err != nil {
+		r.logger.Errorw("can't reload configuration", "err", err)
+		configReloadSuccessMetric.Set(0)
+		return err
+	}
+
+	r.logger.Infow("reloading configuration", "filename", r.filename)
+	r.discovery.Reload(cfg)
+	configReloadSuccessMetric.Set(1)
+	configReloadTimestampMetric.SetToCurrentTime()
+	return nil
+}
+
+// ServeHTTP reloads the configuration on POST requests.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.Reload(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+	}
+}
+
+// Watch reloads the configuration whenever the content of the configuration
+// file or any included file changed, checking every interval.
+func (r *reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.mtx.Lock()
+			changed := r.checksum() != r.sum
+			r.mtx.Unlock()
+			if changed {
+				r.Reload()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
 func argsparse() *arguments {
@@ -73,6 +252,14 @@ func argsparse() *arguments {
 		9800,
 		"port to expose metrics to.",
 	)
+	flag.DurationVar(&args.WatchInterval, "config.watch-interval",
+		0,
+		"interval to check configuration file for changes. (default disabled)",
+	)
+	flag.DurationVar(&args.ShutdownTimeout, "shutdown.timeout",
+		30*time.Second,
+		"timeout to finish the final export and shutdown.",
+	)
 	flag.Parse()
 
 	if fversion {
@@ -96,7 +283,8 @@ func getlogger(lvl zapcore.Level) *zap.Logger {
 
 func sighandler(ctx context.Context, handler func(os.Signal)) {
 	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(ch)
 	for {
 		select {
 		case sig := <-ch:
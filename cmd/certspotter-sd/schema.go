@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/codecentric/certspotter-sd/internal/config"
+)
+
+// configCommand prints the JSON schema or the defaulted configuration and
+// returns the exit code.
+func configCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: certspotter-sd config schema|defaults")
+		return 2
+	}
+
+	switch args[0] {
+	case "schema":
+		schema, err := config.Schema()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "can't generate schema: %s\n", err)
+			return 1
+		}
+		os.Stdout.Write(schema)
+	case "defaults":
+		fmt.Print(config.Defaults())
+	default:
+		fmt.Fprintln(os.Stderr, "usage: certspotter-sd config schema|defaults")
+		return 2
+	}
+	return 0
+}
@@ -6,6 +6,8 @@ require (
 	github.com/google/go-querystring v1.0.0
 	github.com/prometheus/client_golang v1.8.0
 	go.uber.org/zap v1.16.0
+	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b
 	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.0-20200603094226-e3079894b1e8
 )
@@ -30,7 +30,9 @@ type Client struct {
 
 // Config is used for configuring the client.
 type Config struct {
-	Token     string
+	Token string
+	// TokenFunc returns the token for each request if set.
+	TokenFunc func() (string, error)
 	UserAgent string
 }
 
@@ -80,8 +82,14 @@ func (c *Client) Do(ctx context.Context, val interface{}, opts *DoOptions) (*htt
 		return nil, err
 	}
 
-	if c.cfg.Token != "" {
-		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.cfg.Token))
+	token := c.cfg.Token
+	if c.cfg.TokenFunc != nil {
+		if token, err = c.cfg.TokenFunc(); err != nil {
+			return nil, err
+		}
+	}
+	if token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
 	}
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("User-Agent", c.cfg.UserAgent)
@@ -98,6 +98,53 @@ func TestClientDo(t *testing.T) {
 	}
 }
 
+func TestClientDoToken(t *testing.T) {
+	table := map[string]struct {
+		cfg  *Config
+		want string
+		ok   bool
+	}{"no token": {
+		&Config{},
+		"",
+		true,
+	}, "token": {
+		&Config{Token: "secret"},
+		"Bearer secret",
+		true,
+	}, "token func": {
+		&Config{Token: "ignored", TokenFunc: func() (string, error) { return "rotated", nil }},
+		"Bearer rotated",
+		true,
+	}, "token func error": {
+		&Config{TokenFunc: func() (string, error) { return "", errors.New("missing") }},
+		"",
+		false,
+	}}
+
+	ctx := context.Background()
+	cl, mux, stop := setup()
+	defer stop()
+
+	var got string
+	mux.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+		fmt.Fprint(w, `{}`)
+	})
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		got = ""
+		cl.cfg = test.cfg
+		if _, err := cl.Do(ctx, &sample{}, &DoOptions{Path: "/test"}); (err == nil) != test.ok {
+			t.Errorf("got: %v want ok: %t", err, test.ok)
+		}
+		if got != test.want {
+			t.Errorf("got: %q want %q", got, test.want)
+		}
+	}
+}
+
 func TestCheckResponse(t *testing.T) {
 	table := map[string]struct {
 		resp *http.Response
@@ -0,0 +1,150 @@
+// Package check finds problems of configurations which are valid YAML but
+// can't work as intended.
+package check
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/codecentric/certspotter-sd/internal/config"
+	"github.com/codecentric/certspotter-sd/internal/discovery/target"
+)
+
+var (
+	// regexLabelName matches valid prometheus label names.
+	regexLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Problem is a problem of a configuration value.
+type Problem struct {
+	config.Location
+	Message string
+}
+
+// String returns the location and message of problem.
+func (p *Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Location, p.Message)
+}
+
+// Check returns the problems of a loaded configuration. Files written by
+// several file configurations are already rejected while loading.
+func Check(cfg *config.Config) []*Problem {
+	var problems []*Problem
+	problems = append(problems, checkDomains(cfg)...)
+	problems = append(problems, checkFiles(cfg)...)
+	return problems
+}
+
+// checkDomains returns domains covered by other domains including sub
+// domains without options of their own and invalid label names of domains.
+func checkDomains(cfg *config.Config) []*Problem {
+	var problems []*Problem
+	for _, dcfg := range cfg.DomainConfigs {
+		// covered domains are queried along with the covering domain, so
+		// they are only redundant if they don't label or poll differently
+		redundant := len(dcfg.Labels) == 0 && len(dcfg.WildcardHosts) == 0 &&
+			dcfg.Interval == 0 && dcfg.Priority == 0
+		for _, other := range cfg.DomainConfigs {
+			if redundant && other.IncludeSubdomains && strings.HasSuffix(dcfg.Domain, "."+other.Domain) {
+				problems = append(problems, &Problem{
+					Location: cfg.Locate(dcfg, "domain"),
+					Message: fmt.Sprintf("domain %s is already covered by %s including sub domains declared in %s",
+						dcfg.Domain, other.Domain, cfg.Locate(other).Position()),
+				})
+			}
+		}
+		for _, name := range sortedKeys(dcfg.Labels) {
+			if !regexLabelName.MatchString(name) {
+				problems = append(problems, &Problem{
+					Location: cfg.Locate(dcfg, "labels", name),
+					Message:  fmt.Sprintf("label name %q is not a valid prometheus label name", name),
+				})
+			}
+		}
+	}
+	return problems
+}
+
+// checkFiles returns invalid label names of files and match_re labels no
+// target can have.
+func checkFiles(cfg *config.Config) []*Problem {
+	labels := make(map[string]bool)
+	for _, name := range target.MatchLabels {
+		labels[name] = true
+	}
+	if cfg.GlobalConfig.StaleLabel {
+		labels["stale"] = true
+	}
+	if len(cfg.Zones) != 0 {
+		labels["in_zone"] = true
+	}
+	for _, dcfg := range cfg.DomainConfigs {
+		for name := range dcfg.Labels {
+			labels["labels_"+name] = true
+		}
+	}
+
+	var problems []*Problem
+	for _, fcfg := range cfg.FileConfigs {
+		for _, name := range sortedKeys(fcfg.Labels) {
+			if !regexLabelName.MatchString(name) {
+				problems = append(problems, &Problem{
+					Location: cfg.Locate(fcfg, "labels", name),
+					Message:  fmt.Sprintf("label name %q is not a valid prometheus label name", name),
+				})
+			}
+		}
+
+		var names []string
+		for name := range fcfg.MatchRE {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !isSet(labels, fcfg, name) {
+				problems = append(problems, &Problem{
+					Location: cfg.Locate(fcfg, "match_re", name),
+					Message:  fmt.Sprintf("match_re label %s is never set on targets", name),
+				})
+			}
+		}
+
+		for i, sel := range fcfg.Selectors {
+			for _, m := range sel {
+				// missing labels have an empty value
+				name := strings.TrimPrefix(m.Name, "__meta_certspotter_")
+				if (name != m.Name && isSet(labels, fcfg, name)) || m.Matches("") {
+					continue
+				}
+				problems = append(problems, &Problem{
+					Location: cfg.Locate(fcfg, "selectors", i),
+					Message:  fmt.Sprintf("selector %s never matches as label %s is never set on targets", sel, m.Name),
+				})
+			}
+		}
+	}
+	return problems
+}
+
+// isSet returns if targets of file fcfg may have label name without
+// __meta_certspotter_, given the labels set independent of files.
+func isSet(labels map[string]bool, fcfg *config.FileConfig, name string) bool {
+	if name == "days_remaining" {
+		return fcfg.DaysRemaining
+	}
+	// labels of the file itself are added before matching
+	_, own := fcfg.Labels[strings.TrimPrefix(name, "labels_")]
+	return labels[name] || (own && strings.HasPrefix(name, "labels_"))
+}
+
+// sortedKeys returns the sorted keys of labels.
+func sortedKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
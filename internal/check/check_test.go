@@ -0,0 +1,132 @@
+package check
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/codecentric/certspotter-sd/internal/config"
+)
+
+func TestCheck(t *testing.T) {
+	table := map[string]struct {
+		data string
+		want []string
+	}{"valid": {
+		`
+global:
+  stale_label: true
+domains:
+  - domain: example.com
+    labels: {team: web}
+files:
+  - file: targets.json
+    labels: {env: prod}
+    match_re:
+      dns_names: .*
+      stale: "false"
+      labels_team: web
+      labels_env: prod
+`,
+		nil,
+	}, "covered domain": {
+		`
+domains:
+  - domain: example.com
+    include_subdomains: true
+  - domain: shop.example.com
+`,
+		[]string{"configuration:5: domains[1].domain: domain shop.example.com is already covered by example.com including sub domains declared in configuration:3"},
+	}, "covered domain with labels": {
+		`
+domains:
+  - domain: example.com
+    include_subdomains: true
+  - domain: shop.example.com
+    labels:
+      team: shop
+`,
+		nil,
+	}, "invalid label names": {
+		`
+domains:
+  - domain: example.com
+    labels:
+      team-name: web
+files:
+  - file: targets.json
+    labels:
+      1env: prod
+`,
+		[]string{
+			`configuration:5: domains[0].labels.team-name: label name "team-name" is not a valid prometheus label name`,
+			`configuration:9: files[0].labels.1env: label name "1env" is not a valid prometheus label name`,
+		},
+	}, "unknown match_re labels": {
+		`
+domains:
+  - domain: example.com
+files:
+  - file: targets.json
+    match_re:
+      dns_name: .*
+      stale: "true"
+      labels_team: web
+`,
+		[]string{
+			"configuration:7: files[0].match_re.dns_name: match_re label dns_name is never set on targets",
+			"configuration:9: files[0].match_re.labels_team: match_re label labels_team is never set on targets",
+			"configuration:8: files[0].match_re.stale: match_re label stale is never set on targets",
+		},
+	}, "days remaining without opt-in": {
+		`
+domains:
+  - domain: example.com
+files:
+  - file: expiring.json
+    match_re:
+      days_remaining: "[0-6]"
+  - file: labeled.json
+    days_remaining: true
+    match_re:
+      days_remaining: "[0-6]"
+`,
+		[]string{
+			"configuration:7: files[0].match_re.days_remaining: match_re label days_remaining is never set on targets",
+		},
+	}, "unknown selector labels": {
+		`
+domains:
+  - domain: example.com
+files:
+  - file: targets.json
+    labels:
+      team: web
+    selectors:
+      - '{__meta_certspotter_dns_name="example.com"}'
+      - '{issuer_name=~".*Encrypt.*"}'
+      - '{__meta_certspotter_stale!="true", __meta_certspotter_labels_team="web"}'
+      - '{__meta_certspotter_issuer_name=~".*Encrypt.*"}'
+`,
+		[]string{
+			`configuration:9: files[0].selectors[0]: selector {__meta_certspotter_dns_name="example.com"} never matches as label __meta_certspotter_dns_name is never set on targets`,
+			`configuration:10: files[0].selectors[1]: selector {issuer_name=~".*Encrypt.*"} never matches as label issuer_name is never set on targets`,
+		},
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		cfg, err := config.Load(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, problem := range Check(cfg) {
+			got = append(got, problem.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got: %q want: %q", got, test.want)
+		}
+	}
+}
@@ -3,14 +3,28 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"os/user"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"golang.org/x/net/idna"
 	yaml "gopkg.in/yaml.v2"
+
+	"github.com/codecentric/certspotter-sd/internal/zone"
 )
 
 var (
-	regexDomainName = regexp.MustCompile(`^([a-z0-9]+(-[a-z0-9]+)*\.)+[a-z]{2,}$`)
+	// idnaProfile validates and maps domains to A-labels per IDNA 2008.
+	idnaProfile = idna.New(
+		idna.MapForLookup(),
+		idna.BidiRule(),
+		idna.Transitional(false),
+		idna.StrictDomainName(true),
+		idna.VerifyDNSLength(true),
+	)
 )
 
 var (
@@ -21,21 +35,57 @@ var (
 
 	// DefaultGlobalConfig is the default global configuration.
 	DefaultGlobalConfig = GlobalConfig{
-		Interval:  time.Hour,
-		RateLimit: 1.25,
+		Interval:           time.Hour,
+		RateLimit:          1.25,
+		InitialSyncTimeout: time.Minute * 10,
+		ExportDebounce:     time.Second * 10,
+		ExportInterval:     time.Minute * 5,
+	}
+
+	// DefaultAdaptiveConfig is the default adaptive polling configuration.
+	DefaultAdaptiveConfig = AdaptiveConfig{
+		MinInterval: time.Minute * 5,
+		MaxInterval: time.Hour * 24,
 	}
 
 	// DefaultDomainConfig is the default domain configuration.
 	DefaultDomainConfig = DomainConfig{
 		IncludeSubdomains: false,
 	}
+
+	// DefaultFileConfig is the default file configuration.
+	DefaultFileConfig = FileConfig{
+		Mode: 0644,
+		UID:  -1,
+		GID:  -1,
+	}
 )
 
 // Config is the top-level configuration.
 type Config struct {
-	GlobalConfig  GlobalConfig    `yaml:"global"`
+	// Global configuration shared by all domains and files
+	GlobalConfig GlobalConfig `yaml:"global"`
+	// Domains to request certificate issuances for
 	DomainConfigs []*DomainConfig `yaml:"domains"`
-	FileConfigs   []*FileConfig   `yaml:"files"`
+	// Files to export targets to
+	FileConfigs []*FileConfig `yaml:"files"`
+	// Globs of files to read additional domains from
+	DomainFiles []string `yaml:"domain_files"`
+	// Globs of files to read additional file configurations from
+	FileConfigFiles []string `yaml:"file_config_files"`
+	// Globs of zone files to read domains and expected hosts from
+	ZoneFiles []string `yaml:"zone_files"`
+
+	// Zones read from zone files
+	Zones []*zone.Zone `yaml:"-"`
+
+	// filename the configuration was loaded from
+	filename string
+	// patterns of included files resolved relative to filename
+	patterns []string
+	// origins of domain and file configurations for Locate
+	origins map[interface{}]*origin
+	locator *locator
 }
 
 // GlobalConfig configures globally shared values.
@@ -45,7 +95,33 @@ type GlobalConfig struct {
 	// RateLimit to use for certspotter api (configured in Hz).
 	RateLimit float64 `yaml:"rate_limit"`
 	// Token to used for authenticating againts certspotter api.
-	Token string `yaml:"token"`
+	Token Secret `yaml:"token"`
+	// TokenFile to read the token from, re-read when it changes.
+	TokenFile string `yaml:"token_file"`
+	// Quota of certspotter api calls per hour, polling intervals of low
+	// priority domains are stretched to fit it. Unlimited if 0.
+	Quota int `yaml:"quota"`
+	// InitialSyncTimeout to wait for all domains to be synced before
+	// exporting targets.
+	InitialSyncTimeout time.Duration `yaml:"initial_sync_timeout"`
+	// If targets should be labeled with __meta_certspotter_stale.
+	StaleLabel bool `yaml:"stale_label"`
+	// ExportDebounce to coalesce changed issuances into a single export.
+	ExportDebounce time.Duration `yaml:"export_debounce"`
+	// ExportInterval to use between periodic exports.
+	ExportInterval time.Duration `yaml:"export_interval"`
+	// AdaptivePolling adapts polling intervals to the issuance activity of
+	// domains if set.
+	AdaptivePolling *AdaptiveConfig `yaml:"adaptive_polling"`
+}
+
+// AdaptiveConfig configures adaptive polling.
+type AdaptiveConfig struct {
+	// MinInterval to shorten polling intervals of domains with new
+	// issuances to.
+	MinInterval time.Duration `yaml:"min_interval"`
+	// MaxInterval to back off polling intervals of quiet domains to.
+	MaxInterval time.Duration `yaml:"max_interval"`
 }
 
 // DomainConfig configures domain requesting options.
@@ -54,6 +130,16 @@ type DomainConfig struct {
 	Domain string `yaml:"domain"`
 	// If sub domains should be included.
 	IncludeSubdomains bool `yaml:"include_subdomains"`
+	// Labels to add to targets of issuances found for domain.
+	Labels map[string]string `yaml:"labels"`
+	// Hosts to expand wildcard names of issuances found for domain to.
+	WildcardHosts []string `yaml:"wildcard_hosts"`
+	// Interval to use between polling for domain, the global polling
+	// interval if 0s.
+	Interval time.Duration `yaml:"polling_interval"`
+	// Priority of requests for domain contending for the rate limit, higher
+	// priorities are served first.
+	Priority int `yaml:"priority"`
 }
 
 // FileConfig configure a file for exporting issuances.
@@ -64,11 +150,89 @@ type FileConfig struct {
 	Labels map[string]string `yaml:"labels"`
 	// Matches for target to be included in file
 	MatchRE MatchRE `yaml:"match_re"`
+	// Selectors of which any has to match for target to be included in file
+	Selectors Selectors `yaml:"selectors"`
+	// Mode of file
+	Mode FileMode `yaml:"mode"`
+	// Owner of file as user name or id
+	Owner string `yaml:"owner"`
+	// Group of file as group name or id
+	Group string `yaml:"group"`
+	// If missing parent directories should be created
+	CreateDirs bool `yaml:"create_dirs"`
+	// Relabeling applied to each target before export
+	RelabelConfigs []*RelabelConfig `yaml:"relabel_configs"`
+	// Template rendering the address of each host
+	AddressTemplate *Template `yaml:"address_template"`
+	// Hosts to replace before rendering addresses
+	HostOverrides map[string]string `yaml:"host_overrides"`
+	// Ports to expand each host into
+	Ports []*PortConfig `yaml:"ports"`
+	// If ip address sans should be exported as targets
+	IncludeIPSANs bool `yaml:"include_ip_sans"`
+	// If targets should be labeled with __meta_certspotter_days_remaining,
+	// which changes daily and rewrites file every day
+	DaysRemaining bool `yaml:"days_remaining"`
+
+	// LabelTemplates parsed from label values containing {{
+	LabelTemplates map[string]*Template `yaml:"-"`
+
+	// UID resolved from owner or -1
+	UID int `yaml:"-"`
+	// GID resolved from group or -1
+	GID int `yaml:"-"`
+}
+
+// PerHost returns if targets are exported per host because addresses or
+// labels depend on the host.
+func (c *FileConfig) PerHost() bool {
+	return c.AddressTemplate != nil || len(c.HostOverrides) != 0 ||
+		len(c.LabelTemplates) != 0 || len(c.Ports) != 0
+}
+
+// PortConfig configures a port to probe hosts on.
+type PortConfig struct {
+	// Port of the target
+	Port int `yaml:"port"`
+	// Module of the blackbox exporter to probe port with
+	Module string `yaml:"module"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *PortConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain PortConfig
+
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port %d must be between 1 and 65535", c.Port)
+	}
+
+	return nil
 }
 
 // MatchRE represents a map of regex patterns
 type MatchRE map[string]*regexp.Regexp
 
+// MarshalYAML implements the yaml.Marshaler interface.
+func (m MatchRE) MarshalYAML() (interface{}, error) {
+	matches := make(map[string]string, len(m))
+	for name, re := range m {
+		matches[name] = strings.TrimSuffix(strings.TrimPrefix(re.String(), "^"), "$")
+	}
+	return matches, nil
+}
+
+// FileMode represents octal file permissions.
+type FileMode os.FileMode
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (m FileMode) MarshalYAML() (interface{}, error) {
+	return fmt.Sprintf("%04o", uint32(m)), nil
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
 func (c *GlobalConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	*c = DefaultGlobalConfig
@@ -81,16 +245,67 @@ func (c *GlobalConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if c.Interval <= 0 {
 		return fmt.Errorf("polling interval %s must be greater than 0s", c.Interval)
 	}
+	if c.InitialSyncTimeout <= 0 {
+		return fmt.Errorf("initial sync timeout %s must be greater than 0s", c.InitialSyncTimeout)
+	}
+	if c.ExportDebounce < 0 {
+		return fmt.Errorf("export debounce %s must not be negative", c.ExportDebounce)
+	}
+	if c.ExportInterval <= 0 {
+		return fmt.Errorf("export interval %s must be greater than 0s", c.ExportInterval)
+	}
 	if c.RateLimit <= 0 {
 		return fmt.Errorf("rate limit %fHz must be greater than 0Hz", c.RateLimit)
 	}
 	if c.RateLimit > 20 {
 		return fmt.Errorf("rate limit %fHz must be smaller than 20Hz", c.RateLimit)
 	}
+	if c.Quota < 0 {
+		return fmt.Errorf("quota %d must not be negative", c.Quota)
+	}
+	if c.Token != "" && c.TokenFile != "" {
+		return fmt.Errorf("at most one of token and token_file must be set")
+	}
 
 	return nil
 }
 
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *AdaptiveConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultAdaptiveConfig
+	type plain AdaptiveConfig
+
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	if c.MinInterval <= 0 {
+		return fmt.Errorf("min interval %s must be greater than 0s", c.MinInterval)
+	}
+	if c.MaxInterval < c.MinInterval {
+		return fmt.Errorf("max interval %s must not be smaller than min interval %s", c.MaxInterval, c.MinInterval)
+	}
+	return nil
+}
+
+// NormalizeDomain validates domain per IDNA 2008 and returns it in lower case
+// A-labels as used by the certspotter api.
+func NormalizeDomain(domain string) (string, error) {
+	ascii, err := idnaProfile.ToASCII(domain)
+	if err != nil {
+		return "", err
+	}
+
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return "", fmt.Errorf("domain must have at least two labels")
+	}
+	if _, err := strconv.Atoi(labels[len(labels)-1]); err == nil {
+		return "", fmt.Errorf("top level domain must not be numeric")
+	}
+	return ascii, nil
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
 func (c *DomainConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	*c = DefaultDomainConfig
@@ -100,9 +315,130 @@ func (c *DomainConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return err
 	}
 
-	if !regexDomainName.MatchString(c.Domain) {
-		return fmt.Errorf("domain %s must be a valid domain", c.Domain)
+	if c.Interval < 0 {
+		return fmt.Errorf("polling interval %s of domain %s must not be negative", c.Interval, c.Domain)
+	}
+	return c.normalize()
+}
+
+// normalize validates the domain and wildcard hosts and converts them to
+// A-labels.
+func (c *DomainConfig) normalize() error {
+	domain, err := NormalizeDomain(c.Domain)
+	if err != nil {
+		return fmt.Errorf("domain %s must be a valid domain: %w", c.Domain, err)
+	}
+	c.Domain = domain
+	for i, host := range c.WildcardHosts {
+		if c.WildcardHosts[i], err = NormalizeDomain(host); err != nil {
+			return fmt.Errorf("wildcard host %s of domain %s must be a valid domain: %w", host, c.Domain, err)
+		}
+	}
+
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *FileConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultFileConfig
+	type plain FileConfig
+
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	if c.File == "" {
+		return fmt.Errorf("file must not be empty")
+	}
+	for name, val := range c.Labels {
+		if !strings.Contains(val, "{{") {
+			continue
+		}
+		tmpl, err := NewTemplate(val)
+		if err != nil {
+			return fmt.Errorf("label %s of file %s: %w", name, c.File, err)
+		}
+		if c.LabelTemplates == nil {
+			c.LabelTemplates = make(map[string]*Template)
+		}
+		c.LabelTemplates[name] = tmpl
+	}
+	if c.Owner != "" {
+		uid, err := lookupID(c.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("owner %s of file %s: %w", c.Owner, c.File, err)
+		}
+		c.UID = uid
+	}
+	if c.Group != "" {
+		gid, err := lookupID(c.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("group %s of file %s: %w", c.Group, c.File, err)
+		}
+		c.GID = gid
+	}
+
+	return nil
+}
+
+// lookupID returns the numeric id or looks up the id of name.
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	str, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(str)
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+// Modes are accepted as octal strings or YAML octal integers.
+func (m *FileMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var val interface{}
+	if err := unmarshal(&val); err != nil {
+		return err
+	}
+
+	var mode uint64
+	switch v := val.(type) {
+	case int:
+		// plain decimal integers like 400 aren't meant as octal modes
+		var text string
+		if err := unmarshal(&text); err != nil {
+			return err
+		}
+		if v != 0 && !strings.HasPrefix(text, "0") {
+			return fmt.Errorf("mode %s must be octal, e.g. 0%s", text, text)
+		}
+		mode = uint64(v)
+	case string:
+		parsed, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			return fmt.Errorf("mode %s must be octal: %w", v, err)
+		}
+		mode = parsed
+	default:
+		return fmt.Errorf("mode %v must be octal", val)
+	}
+
+	if mode > 0777 {
+		return fmt.Errorf("mode %o must be a permission mode", mode)
 	}
+	*m = FileMode(mode)
 
 	return nil
 }
@@ -127,18 +463,45 @@ func (m *MatchRE) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
-// Load parses the YAML input s into a Config.
+// Load parses the YAML input s into a Config. Included files are resolved
+// relative to the working directory.
 func Load(data string) (*Config, error) {
+	cfg, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.include("", []byte(data)); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parse parses the YAML input s into a Config without included files.
+func parse(data string) (*Config, error) {
 	cfg := &Config{}
 	*cfg = DefaultConfig
 
-	err := yaml.UnmarshalStrict([]byte(data), cfg)
+	expanded, err := expandDocument([]byte(data))
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.UnmarshalStrict(expanded, cfg)
 	if err != nil {
 		return nil, err
 	}
 	return cfg, nil
 }
 
+// String returns the configuration as YAML with secrets redacted.
+func (c *Config) String() string {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("<error marshaling config: %s>", err)
+	}
+	return string(data)
+}
+
 // LoadFile parses the given YAML file into a Config.
 func LoadFile(filename string) (*Config, error) {
 	content, err := ioutil.ReadFile(filename)
@@ -146,9 +509,12 @@ func LoadFile(filename string) (*Config, error) {
 		return nil, err
 	}
 
-	cfg, err := Load(string(content))
+	cfg, err := parse(string(content))
 	if err != nil {
 		return nil, fmt.Errorf("parsing YAML file %s: %w", filename, err)
 	}
+	if err := cfg.include(filename, content); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
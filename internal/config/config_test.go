@@ -0,0 +1,303 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestLoadFileConfig(t *testing.T) {
+	table := map[string]struct {
+		data string
+		want *FileConfig
+		ok   bool
+	}{"defaults": {
+		`{file: targets.json}`,
+		&FileConfig{File: "targets.json", Mode: 0644, UID: -1, GID: -1},
+		true,
+	}, "octal string mode": {
+		`{file: targets.json, mode: "0640"}`,
+		&FileConfig{File: "targets.json", Mode: 0640, UID: -1, GID: -1},
+		true,
+	}, "octal integer mode": {
+		`{file: targets.json, mode: 0600}`,
+		&FileConfig{File: "targets.json", Mode: 0600, UID: -1, GID: -1},
+		true,
+	}, "numeric owner": {
+		`{file: targets.json, owner: "` + strconv.Itoa(os.Getuid()) + `", create_dirs: true}`,
+		&FileConfig{
+			File: "targets.json", Mode: 0644, CreateDirs: true,
+			Owner: strconv.Itoa(os.Getuid()), UID: os.Getuid(), GID: -1,
+		},
+		true,
+	}, "include ip sans": {
+		`{file: targets.json, include_ip_sans: true}`,
+		&FileConfig{File: "targets.json", Mode: 0644, UID: -1, GID: -1, IncludeIPSANs: true},
+		true,
+	}, "invalid mode": {
+		`{file: targets.json, mode: "0999"}`,
+		nil,
+		false,
+	}, "decimal integer mode": {
+		`{file: targets.json, mode: 400}`,
+		nil,
+		false,
+	}, "unknown group": {
+		`{file: targets.json, group: certspotter-sd-missing}`,
+		nil,
+		false,
+	}, "label templates": {
+		`{file: targets.json, labels: {team: web, host: "{{.Host}}"}}`,
+		&FileConfig{
+			File: "targets.json", Mode: 0644, UID: -1, GID: -1,
+			Labels:         map[string]string{"team": "web", "host": "{{.Host}}"},
+			LabelTemplates: map[string]*Template{"host": MustNewTemplate("{{.Host}}")},
+		},
+		true,
+	}, "invalid label template": {
+		`{file: targets.json, labels: {host: "{{.Host"}}`,
+		nil,
+		false,
+	}, "invalid address template": {
+		`{file: targets.json, address_template: "{{.Host}:443"}`,
+		nil,
+		false,
+	}, "ports": {
+		`{file: targets.json, ports: [{port: 443, module: tls}, {port: 993}]}`,
+		&FileConfig{
+			File: "targets.json", Mode: 0644, UID: -1, GID: -1,
+			Ports: []*PortConfig{&PortConfig{Port: 443, Module: "tls"}, &PortConfig{Port: 993}},
+		},
+		true,
+	}, "invalid port": {
+		`{file: targets.json, ports: [{port: 70000}]}`,
+		nil,
+		false,
+	}, "missing file": {
+		`{mode: "0644"}`,
+		nil,
+		false,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		cfg, err := Load("files: [" + test.data + "]")
+		if (err == nil) != test.ok {
+			t.Errorf("got: %v want ok: %t", err, test.ok)
+		}
+		if !test.ok {
+			continue
+		}
+		// templates hold functions, compare them by their source
+		got := cfg.FileConfigs[0]
+		if got, want := templates(got.LabelTemplates), templates(test.want.LabelTemplates); !reflect.DeepEqual(got, want) {
+			t.Errorf("got: %v want: %v", got, want)
+		}
+		got.LabelTemplates, test.want.LabelTemplates = nil, nil
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got: %#v want: %#v", got, test.want)
+		}
+	}
+}
+
+func templates(tmpls map[string]*Template) map[string]string {
+	strs := make(map[string]string, len(tmpls))
+	for name, tmpl := range tmpls {
+		strs[name] = tmpl.String()
+	}
+	return strs
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	table := map[string]struct {
+		domain string
+		want   string
+		ok     bool
+	}{"ascii": {
+		"example.com", "example.com", true,
+	}, "uppercase": {
+		"WWW.Example.COM", "www.example.com", true,
+	}, "unicode": {
+		"bücher.example.de", "xn--bcher-kva.example.de", true,
+	}, "punycode": {
+		"xn--bcher-kva.example.de", "xn--bcher-kva.example.de", true,
+	}, "tld with digits": {
+		"example.xn--p1ai", "example.xn--p1ai", true,
+	}, "single label": {
+		"localhost", "", false,
+	}, "numeric tld": {
+		"192.0.2.1", "", false,
+	}, "empty label": {
+		"example..com", "", false,
+	}, "underscore": {
+		"_dmarc.example.com", "", false,
+	}, "invalid punycode": {
+		"xn--a.example.com", "", false,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		got, err := NormalizeDomain(test.domain)
+		if (err == nil) != test.ok {
+			t.Errorf("got: %v want ok: %t", err, test.ok)
+		}
+		if got != test.want {
+			t.Errorf("got: %s want: %s", got, test.want)
+		}
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("CERTSPOTTER_SD_TEST_TOKEN", "secret")
+	defer os.Unsetenv("CERTSPOTTER_SD_TEST_TOKEN")
+
+	table := map[string]struct {
+		data string
+		want string
+		ok   bool
+	}{"set variable": {
+		"token: ${CERTSPOTTER_SD_TEST_TOKEN}",
+		"token: secret",
+		true,
+	}, "default": {
+		"token: ${CERTSPOTTER_SD_TEST_MISSING:-fallback}",
+		"token: fallback",
+		true,
+	}, "empty default": {
+		"token: '${CERTSPOTTER_SD_TEST_MISSING:-}'",
+		"token: ''",
+		true,
+	}, "escaped": {
+		"replacement: $${1}-$$HOME",
+		"replacement: ${1}-$HOME",
+		true,
+	}, "regex groups": {
+		"replacement: ${1}_$1",
+		"replacement: ${1}_$1",
+		true,
+	}, "missing variable": {
+		"token: ${CERTSPOTTER_SD_TEST_MISSING}",
+		"",
+		false,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		got, err := ExpandEnv(test.data)
+		if (err == nil) != test.ok {
+			t.Errorf("got: %v want ok: %t", err, test.ok)
+		}
+		if got != test.want {
+			t.Errorf("got: %s want: %s", got, test.want)
+		}
+	}
+}
+
+func TestExpandDocument(t *testing.T) {
+	os.Setenv("CERTSPOTTER_SD_TEST_TOKEN", "a # b: c\nd")
+	os.Setenv("CERTSPOTTER_SD_TEST_PORT", "9000")
+	defer os.Unsetenv("CERTSPOTTER_SD_TEST_TOKEN")
+	defer os.Unsetenv("CERTSPOTTER_SD_TEST_PORT")
+
+	table := map[string]struct {
+		data string
+		want map[string]interface{}
+		ok   bool
+	}{"structural characters": {
+		"token: ${CERTSPOTTER_SD_TEST_TOKEN}",
+		map[string]interface{}{"token": "a # b: c\nd"},
+		true,
+	}, "quoted value": {
+		"token: '${CERTSPOTTER_SD_TEST_TOKEN}'",
+		map[string]interface{}{"token": "a # b: c\nd"},
+		true,
+	}, "plain value resolved": {
+		"port: ${CERTSPOTTER_SD_TEST_PORT}",
+		map[string]interface{}{"port": 9000},
+		true,
+	}, "quoted value kept as string": {
+		"port: '${CERTSPOTTER_SD_TEST_PORT}'",
+		map[string]interface{}{"port": "9000"},
+		true,
+	}, "comment": {
+		"# token: ${CERTSPOTTER_SD_TEST_MISSING}\nport: 80 # ${CERTSPOTTER_SD_TEST_MISSING}",
+		map[string]interface{}{"port": 80},
+		true,
+	}, "relabel groups": {
+		"replacement: ${1}-${name}\ntarget_label: ${1}",
+		map[string]interface{}{"replacement": "${1}-${name}", "target_label": "${1}"},
+		true,
+	}, "escaped": {
+		"token: $${CERTSPOTTER_SD_TEST_TOKEN}",
+		map[string]interface{}{"token": "${CERTSPOTTER_SD_TEST_TOKEN}"},
+		true,
+	}, "missing variable": {
+		"token: ${CERTSPOTTER_SD_TEST_MISSING}",
+		nil,
+		false,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		data, err := expandDocument([]byte(test.data))
+		if (err == nil) != test.ok {
+			t.Errorf("got: %v want ok: %t", err, test.ok)
+		}
+		if err != nil {
+			continue
+		}
+
+		var got map[string]interface{}
+		if err := yaml.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got: %v want: %v", got, test.want)
+		}
+	}
+}
+
+func TestConfigString(t *testing.T) {
+	cfg, err := Load(`
+global:
+  token: very-secret
+domains:
+  - domain: example.com
+files:
+  - file: targets.json
+    match_re:
+      dns_names: .*example.*
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	str := cfg.String()
+	if strings.Contains(str, "very-secret") || !strings.Contains(str, "token: <secret>") {
+		t.Errorf("got: %s want: redacted token", str)
+	}
+	if got := fmt.Sprint(cfg.GlobalConfig.Token); got != "<secret>" {
+		t.Errorf("got: %s want: <secret>", got)
+	}
+
+	reloaded, err := Load(strings.Replace(str, "<secret>", "very-secret", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.GlobalConfig.Token != "very-secret" || reloaded.String() != str {
+		t.Errorf("got: %s want: %s", reloaded, str)
+	}
+
+	if _, err := Load("global: {token: a, token_file: b}"); err == nil {
+		t.Errorf("got: no error want: error for token and token_file")
+	}
+}
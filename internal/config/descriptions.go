@@ -0,0 +1,61 @@
+// Code generated by "go test -run TestDescriptions -update"; DO NOT EDIT.
+
+package config
+
+// descriptions of configuration structs and fields from their comments.
+var descriptions = map[string]string{
+	"AdaptiveConfig":                  "AdaptiveConfig configures adaptive polling.",
+	"AdaptiveConfig.MaxInterval":      "MaxInterval to back off polling intervals of quiet domains to.",
+	"AdaptiveConfig.MinInterval":      "MinInterval to shorten polling intervals of domains with new issuances to.",
+	"Config":                          "Config is the top-level configuration.",
+	"Config.DomainConfigs":            "Domains to request certificate issuances for",
+	"Config.DomainFiles":              "Globs of files to read additional domains from",
+	"Config.FileConfigFiles":          "Globs of files to read additional file configurations from",
+	"Config.FileConfigs":              "Files to export targets to",
+	"Config.GlobalConfig":             "Global configuration shared by all domains and files",
+	"Config.ZoneFiles":                "Globs of zone files to read domains and expected hosts from",
+	"DomainConfig":                    "DomainConfig configures domain requesting options.",
+	"DomainConfig.Domain":             "Domain to use for requesting certificate issuances.",
+	"DomainConfig.IncludeSubdomains":  "If sub domains should be included.",
+	"DomainConfig.Interval":           "Interval to use between polling for domain, the global polling interval if 0s.",
+	"DomainConfig.Labels":             "Labels to add to targets of issuances found for domain.",
+	"DomainConfig.Priority":           "Priority of requests for domain contending for the rate limit, higher priorities are served first.",
+	"DomainConfig.WildcardHosts":      "Hosts to expand wildcard names of issuances found for domain to.",
+	"FileConfig":                      "FileConfig configure a file for exporting issuances.",
+	"FileConfig.AddressTemplate":      "Template rendering the address of each host",
+	"FileConfig.CreateDirs":           "If missing parent directories should be created",
+	"FileConfig.DaysRemaining":        "If targets should be labeled with __meta_certspotter_days_remaining, which changes daily and rewrites file every day",
+	"FileConfig.File":                 "Filename to export targets to",
+	"FileConfig.Group":                "Group of file as group name or id",
+	"FileConfig.HostOverrides":        "Hosts to replace before rendering addresses",
+	"FileConfig.IncludeIPSANs":        "If ip address sans should be exported as targets",
+	"FileConfig.Labels":               "Labels to add to targets before export",
+	"FileConfig.MatchRE":              "Matches for target to be included in file",
+	"FileConfig.Mode":                 "Mode of file",
+	"FileConfig.Owner":                "Owner of file as user name or id",
+	"FileConfig.Ports":                "Ports to expand each host into",
+	"FileConfig.RelabelConfigs":       "Relabeling applied to each target before export",
+	"FileConfig.Selectors":            "Selectors of which any has to match for target to be included in file",
+	"GlobalConfig":                    "GlobalConfig configures globally shared values.",
+	"GlobalConfig.AdaptivePolling":    "AdaptivePolling adapts polling intervals to the issuance activity of domains if set.",
+	"GlobalConfig.ExportDebounce":     "ExportDebounce to coalesce changed issuances into a single export.",
+	"GlobalConfig.ExportInterval":     "ExportInterval to use between periodic exports.",
+	"GlobalConfig.InitialSyncTimeout": "InitialSyncTimeout to wait for all domains to be synced before exporting targets.",
+	"GlobalConfig.Interval":           "Interval to use between polling the certspotter api.",
+	"GlobalConfig.Quota":              "Quota of certspotter api calls per hour, polling intervals of low priority domains are stretched to fit it. Unlimited if 0.",
+	"GlobalConfig.RateLimit":          "RateLimit to use for certspotter api (configured in Hz).",
+	"GlobalConfig.StaleLabel":         "If targets should be labeled with __meta_certspotter_stale.",
+	"GlobalConfig.Token":              "Token to used for authenticating againts certspotter api.",
+	"GlobalConfig.TokenFile":          "TokenFile to read the token from, re-read when it changes.",
+	"PortConfig":                      "PortConfig configures a port to probe hosts on.",
+	"PortConfig.Module":               "Module of the blackbox exporter to probe port with",
+	"PortConfig.Port":                 "Port of the target",
+	"RelabelConfig":                   "RelabelConfig configures relabeling of targets with prometheus semantics.",
+	"RelabelConfig.Action":            "Action to perform based on regex matching.",
+	"RelabelConfig.Modulus":           "Modulus to take of the hash of the value.",
+	"RelabelConfig.Regex":             "Regex against which the value is matched.",
+	"RelabelConfig.Replacement":       "Replacement to write to target label, may refer to regex groups.",
+	"RelabelConfig.Separator":         "Separator placed between concatenated source label values.",
+	"RelabelConfig.SourceLabels":      "SourceLabels to concatenate as value.",
+	"RelabelConfig.TargetLabel":       "TargetLabel to which the resulting value is written.",
+}
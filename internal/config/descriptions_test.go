@@ -0,0 +1,116 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update generated descriptions and golden files")
+
+func TestDescriptions(t *testing.T) {
+	want, err := parseDescriptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		if err := writeDescriptions("descriptions.go", want); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if !reflect.DeepEqual(descriptions, want) {
+		t.Errorf("descriptions.go is out of date, run go generate ./internal/config")
+	}
+}
+
+// parseDescriptions returns the comments of structs with YAML fields and
+// their fields from the sources of package config.
+func parseDescriptions() (map[string]string, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	descs := make(map[string]string)
+	for _, file := range pkgs["config"].Files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+
+				var yamlFields bool
+				for _, field := range st.Fields.List {
+					if field.Tag == nil || len(field.Names) == 0 || !field.Names[0].IsExported() {
+						continue
+					}
+					tag := reflect.StructTag(strings.Trim(field.Tag.Value, "`")).Get("yaml")
+					if tag == "" || tag == "-" {
+						continue
+					}
+					yamlFields = true
+					if field.Doc != nil {
+						descs[ts.Name.Name+"."+field.Names[0].Name] = oneline(field.Doc.Text())
+					}
+				}
+				if doc := gen.Doc; yamlFields && doc != nil {
+					descs[ts.Name.Name] = oneline(doc.Text())
+				}
+			}
+		}
+	}
+	return descs, nil
+}
+
+// oneline joins the lines of comment.
+func oneline(comment string) string {
+	return strings.Join(strings.Fields(comment), " ")
+}
+
+// writeDescriptions writes descs as source of the descriptions variable.
+func writeDescriptions(filename string, descs map[string]string) error {
+	keys := make([]string, 0, len(descs))
+	for key := range descs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, `// Code generated by "go test -run TestDescriptions -update"; DO NOT EDIT.`)
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "package config")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "// descriptions of configuration structs and fields from their comments.")
+	fmt.Fprintln(&buf, "var descriptions = map[string]string{")
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%q: %q,\n", key, descs[key])
+	}
+	fmt.Fprintln(&buf, "}")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, src, 0644)
+}
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/codecentric/certspotter-sd/internal/zone"
+)
+
+// include resolves domain_files, zone_files and file_config_files relative
+// to the directory of filename, merges the included domains and file
+// configurations and rejects duplicates reporting the source which declared
+// them. Origins of zones are added as domains including sub domains unless
+// they are declared explicitly. Data is the content of filename.
+func (c *Config) include(filename string, data []byte) error {
+	source := filename
+	if source == "" {
+		source = "configuration"
+	}
+	c.filename = filename
+	c.locator = newLocator()
+	c.locator.add(source, data)
+	c.origins = make(map[interface{}]*origin)
+
+	domains := make(map[string]*DomainConfig)
+	var dcfgs []*DomainConfig
+	addDomains := func(cfgs []*DomainConfig) error {
+		for _, dcfg := range cfgs {
+			if prev, ok := domains[dcfg.Domain]; ok {
+				return fmt.Errorf("domain %s declared in %s is already declared in %s",
+					dcfg.Domain, c.Locate(dcfg).Position(), c.Locate(prev).Position())
+			}
+			domains[dcfg.Domain] = dcfg
+			dcfgs = append(dcfgs, dcfg)
+		}
+		return nil
+	}
+
+	files := make(map[string]*FileConfig)
+	var fcfgs []*FileConfig
+	addFiles := func(cfgs []*FileConfig) error {
+		for _, fcfg := range cfgs {
+			if prev, ok := files[fcfg.File]; ok {
+				return fmt.Errorf("file %s declared in %s is already declared in %s",
+					fcfg.File, c.Locate(fcfg, "file").Position(), c.Locate(prev, "file").Position())
+			}
+			files[fcfg.File] = fcfg
+			fcfgs = append(fcfgs, fcfg)
+		}
+		return nil
+	}
+
+	for i, dcfg := range c.DomainConfigs {
+		c.origins[dcfg] = &origin{filename: source, keys: []interface{}{"domains", i}}
+	}
+	for i, fcfg := range c.FileConfigs {
+		c.origins[fcfg] = &origin{filename: source, keys: []interface{}{"files", i}}
+	}
+	if err := addDomains(c.DomainConfigs); err != nil {
+		return err
+	}
+	if err := addFiles(c.FileConfigs); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(filename)
+	c.patterns = nil
+	for _, pattern := range c.DomainFiles {
+		matches, err := c.glob(dir, pattern)
+		if err != nil {
+			return fmt.Errorf("domain files %s: %w", pattern, err)
+		}
+		for _, match := range matches {
+			cfgs, err := c.loadDomainFile(match)
+			if err != nil {
+				return err
+			}
+			if err := addDomains(cfgs); err != nil {
+				return err
+			}
+		}
+	}
+	zones := make(map[string]string)
+	c.Zones = nil
+	for _, pattern := range c.ZoneFiles {
+		matches, err := c.glob(dir, pattern)
+		if err != nil {
+			return fmt.Errorf("zone files %s: %w", pattern, err)
+		}
+		for _, match := range matches {
+			z, err := zone.ParseFile(match)
+			if err != nil {
+				return err
+			}
+			domain, err := NormalizeDomain(z.Origin)
+			if err != nil {
+				return fmt.Errorf("origin %s of zone file %s must be a valid domain: %w", z.Origin, match, err)
+			}
+			if prev, ok := zones[domain]; ok {
+				return fmt.Errorf("zone %s declared in %s is already declared in %s", domain, match, prev)
+			}
+			zones[domain] = match
+			z.Origin = domain
+			c.Zones = append(c.Zones, z)
+
+			// explicitly declared domains take precedence over zones
+			if _, ok := domains[domain]; ok {
+				continue
+			}
+			dcfg := DefaultDomainConfig
+			dcfg.Domain, dcfg.IncludeSubdomains = domain, true
+			c.origins[&dcfg] = &origin{filename: match}
+			domains[domain] = &dcfg
+			dcfgs = append(dcfgs, &dcfg)
+		}
+	}
+	for _, pattern := range c.FileConfigFiles {
+		matches, err := c.glob(dir, pattern)
+		if err != nil {
+			return fmt.Errorf("file config files %s: %w", pattern, err)
+		}
+		for _, match := range matches {
+			cfgs, err := c.loadFileConfigFile(match)
+			if err != nil {
+				return err
+			}
+			if err := addFiles(cfgs); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.DomainConfigs, c.FileConfigs = dcfgs, fcfgs
+	return nil
+}
+
+// glob returns the files matching pattern relative to dir and remembers the
+// pattern for Sources.
+func (c *Config) glob(dir, pattern string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	c.patterns = append(c.patterns, pattern)
+	return matches, nil
+}
+
+// Sources returns the configuration file and all files currently matching
+// its domain_files, zone_files and file_config_files.
+func (c *Config) Sources() []string {
+	var sources []string
+	if c.filename != "" {
+		sources = append(sources, c.filename)
+	}
+	for _, pattern := range c.patterns {
+		matches, _ := filepath.Glob(pattern)
+		sources = append(sources, matches...)
+	}
+	return sources
+}
+
+// loadDomainFile reads domain configurations from a YAML list in files ending
+// with .yml or .yaml, otherwise from plain text with one domain per line.
+func (c *Config) loadDomainFile(filename string) ([]*DomainConfig, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if isYAML(filename) {
+		data, err := expandDocument(content)
+		if err != nil {
+			return nil, fmt.Errorf("parsing domain file %s: %w", filename, err)
+		}
+		var cfgs []*DomainConfig
+		if err := yaml.UnmarshalStrict(data, &cfgs); err != nil {
+			return nil, fmt.Errorf("parsing domain file %s: %w", filename, err)
+		}
+		c.locator.add(filename, content)
+		for i, dcfg := range cfgs {
+			c.origins[dcfg] = &origin{filename: filename, keys: []interface{}{i}}
+		}
+		return cfgs, nil
+	}
+
+	var cfgs []*DomainConfig
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line, err := ExpandEnv(strings.TrimSpace(line))
+		if err != nil {
+			return nil, fmt.Errorf("parsing domain file %s:%d: %w", filename, lineno, err)
+		}
+		if line == "" {
+			continue
+		}
+
+		dcfg := DefaultDomainConfig
+		dcfg.Domain = line
+		if err := dcfg.normalize(); err != nil {
+			return nil, fmt.Errorf("parsing domain file %s:%d: %w", filename, lineno, err)
+		}
+		c.origins[&dcfg] = &origin{filename: filename, line: lineno}
+		cfgs = append(cfgs, &dcfg)
+	}
+	return cfgs, nil
+}
+
+// loadFileConfigFile reads file configurations from a YAML list.
+func (c *Config) loadFileConfigFile(filename string) ([]*FileConfig, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	data, err := expandDocument(content)
+	if err != nil {
+		return nil, fmt.Errorf("parsing file config file %s: %w", filename, err)
+	}
+
+	var cfgs []*FileConfig
+	if err := yaml.UnmarshalStrict(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("parsing file config file %s: %w", filename, err)
+	}
+	c.locator.add(filename, content)
+	for i, fcfg := range cfgs {
+		c.origins[fcfg] = &origin{filename: filename, keys: []interface{}{i}}
+	}
+	return cfgs, nil
+}
+
+// isYAML returns if filename has a YAML extension.
+func isYAML(filename string) bool {
+	ext := filepath.Ext(filename)
+	return ext == ".yml" || ext == ".yaml"
+}
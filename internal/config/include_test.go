@@ -0,0 +1,155 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadFileIncludes(t *testing.T) {
+	table := map[string]struct {
+		files   map[string]string
+		domains []string
+		targets []string
+		zones   []string
+		err     string
+	}{"merged": {
+		map[string]string{
+			"teams/web.txt":  "# web team\nexample.com\n\nBücher.example.de  # shop\n",
+			"teams/mail.yml": "- domain: example.org\n  include_subdomains: true\n",
+			"files/mail.yml": "- file: mail.json\n",
+		},
+		[]string{"example.net", "example.org", "example.com", "xn--bcher-kva.example.de"},
+		[]string{"targets.json", "mail.json"},
+		nil,
+		"",
+	}, "zones": {
+		map[string]string{
+			"zones/example.net.zone": "$ORIGIN example.net.\n@ SOA ns1 hostmaster 1 2 3 4 5\nwww A 192.0.2.1\n",
+			"zones/example.org.zone": "$ORIGIN Example.org.\n@ SOA ns1 hostmaster 1 2 3 4 5\n",
+		},
+		[]string{"example.net", "example.org"},
+		[]string{"targets.json"},
+		[]string{"example.net", "example.org"},
+		"",
+	}, "duplicate zone": {
+		map[string]string{
+			"zones/a.zone": "$ORIGIN example.org.\n@ SOA ns1 hostmaster 1 2 3 4 5\n",
+			"zones/b.zone": "example.org. SOA ns1.example.org. hostmaster.example.org. 1 2 3 4 5\n",
+		},
+		nil,
+		nil,
+		nil,
+		"zone example.org declared in zones/b.zone is already declared in zones/a.zone",
+	}, "duplicate domain": {
+		map[string]string{
+			"teams/web.txt": "example.com\nexample.net\n",
+		},
+		nil,
+		nil,
+		nil,
+		"domain example.net declared in teams/web.txt:2 is already declared in certspotter-sd.yml:3",
+	}, "duplicate domain across files": {
+		map[string]string{
+			"teams/a.txt":  "example.com\n",
+			"teams/b.yaml": "- domain: Example.com\n",
+		},
+		nil,
+		nil,
+		nil,
+		"domain example.com declared in teams/b.yaml:1 is already declared in teams/a.txt:1",
+	}, "duplicate file": {
+		map[string]string{
+			"files/web.yml": "- file: targets.json\n",
+		},
+		nil,
+		nil,
+		nil,
+		"file targets.json declared in files/web.yml:1 is already declared in certspotter-sd.yml:5",
+	}, "invalid domain": {
+		map[string]string{
+			"teams/web.txt": "example.com\nlocalhost\n",
+		},
+		nil,
+		nil,
+		nil,
+		"parsing domain file teams/web.txt:2",
+	}, "unknown field": {
+		map[string]string{
+			"files/web.yml": "- file: web.json\n  unknown: true\n",
+		},
+		nil,
+		nil,
+		nil,
+		"parsing file config file files/web.yml",
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		dir, err := ioutil.TempDir("", "certspotter-sd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		test.files["certspotter-sd.yml"] = `
+domains:
+  - domain: example.net
+files:
+  - file: targets.json
+domain_files: [teams/*]
+file_config_files: [files/*.yml]
+zone_files: [zones/*.zone]
+`
+		for filename, data := range test.files {
+			filename = filepath.Join(dir, filename)
+			if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		cfg, err := LoadFile(filepath.Join(dir, "certspotter-sd.yml"))
+		if test.err != "" {
+			// sources are reported with their path, strip the temporary directory
+			if err == nil || !strings.Contains(strings.Replace(err.Error(), dir+"/", "", -1), test.err) {
+				t.Errorf("got: %v want: %s", err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+
+		var domains, targets []string
+		for _, dcfg := range cfg.DomainConfigs {
+			domains = append(domains, dcfg.Domain)
+		}
+		for _, fcfg := range cfg.FileConfigs {
+			targets = append(targets, fcfg.File)
+		}
+		if !reflect.DeepEqual(domains, test.domains) {
+			t.Errorf("got: %v want: %v", domains, test.domains)
+		}
+		if !reflect.DeepEqual(targets, test.targets) {
+			t.Errorf("got: %v want: %v", targets, test.targets)
+		}
+		var zones []string
+		for _, z := range cfg.Zones {
+			zones = append(zones, z.Origin)
+		}
+		if !reflect.DeepEqual(zones, test.zones) {
+			t.Errorf("got: %v want: %v", zones, test.zones)
+		}
+		if got := len(cfg.Sources()); got != len(test.files) {
+			t.Errorf("got: %d sources want: %d", got, len(test.files))
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Location is the position of a configuration value in its source.
+type Location struct {
+	// Filename the value was declared in.
+	Filename string
+	// Line the value was declared on or 0 if unknown.
+	Line int
+	// Path of the value within the file, e.g. files[0].labels.team
+	Path string
+}
+
+// Position returns the filename and line of location.
+func (l Location) Position() string {
+	if l.Line == 0 {
+		return l.Filename
+	}
+	return fmt.Sprintf("%s:%d", l.Filename, l.Line)
+}
+
+// String returns the position and path of location.
+func (l Location) String() string {
+	if l.Path == "" {
+		return l.Position()
+	}
+	return fmt.Sprintf("%s: %s", l.Position(), l.Path)
+}
+
+// origin is the source of a domain or file configuration.
+type origin struct {
+	filename string
+	// keys of the configuration within the YAML document of filename
+	keys []interface{}
+	// line of the configuration in plain text files
+	line int
+}
+
+// locator resolves paths in YAML documents to line numbers.
+type locator struct {
+	data  map[string][]byte
+	nodes map[string]*yaml3.Node
+}
+
+// newLocator returns an empty locator.
+func newLocator() *locator {
+	return &locator{data: make(map[string][]byte), nodes: make(map[string]*yaml3.Node)}
+}
+
+// add adds the YAML document of filename.
+func (l *locator) add(filename string, data []byte) {
+	l.data[filename] = data
+}
+
+// locate returns the location of the value at keys in the document of
+// filename. Keys are mapping keys or sequence indexes. The line of the
+// closest existing parent is returned if the value is not declared.
+func (l *locator) locate(filename string, keys ...interface{}) Location {
+	loc := Location{Filename: filename, Path: path(keys)}
+
+	node, ok := l.nodes[filename]
+	if !ok {
+		node = &yaml3.Node{}
+		if err := yaml3.Unmarshal(l.data[filename], node); err != nil {
+			node = nil
+		}
+		l.nodes[filename] = node
+	}
+	if node == nil || len(node.Content) == 0 {
+		return loc
+	}
+
+	node = node.Content[0]
+	loc.Line = node.Line
+	for _, key := range keys {
+		var next *yaml3.Node
+		switch k := key.(type) {
+		case string:
+			if node.Kind != yaml3.MappingNode {
+				return loc
+			}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == k {
+					loc.Line = node.Content[i].Line
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case int:
+			if node.Kind != yaml3.SequenceNode || k >= len(node.Content) {
+				return loc
+			}
+			next = node.Content[k]
+			loc.Line = next.Line
+		}
+		if next == nil {
+			return loc
+		}
+		node = next
+	}
+	return loc
+}
+
+// path formats keys as path, e.g. files[0].labels.team
+func path(keys []interface{}) string {
+	var b strings.Builder
+	for _, key := range keys {
+		switch k := key.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", k)
+		default:
+			if b.Len() != 0 {
+				b.WriteByte('.')
+			}
+			fmt.Fprint(&b, k)
+		}
+	}
+	return b.String()
+}
+
+// Locate returns the location of a domain or file configuration or of the
+// value at keys within it.
+func (c *Config) Locate(cfg interface{}, keys ...interface{}) Location {
+	o, ok := c.origins[cfg]
+	if !ok || c.locator == nil {
+		return Location{Filename: "configuration"}
+	}
+	if o.line != 0 {
+		return Location{Filename: o.filename, Line: o.line}
+	}
+	return c.locator.locate(o.filename, append(append([]interface{}{}, o.keys...), keys...)...)
+}
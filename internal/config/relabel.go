@@ -0,0 +1,139 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RelabelAction is the action to be performed on relabeling.
+type RelabelAction string
+
+// Possible relabel actions, see prometheus relabel_config for details.
+const (
+	RelabelReplace   RelabelAction = "replace"
+	RelabelKeep      RelabelAction = "keep"
+	RelabelDrop      RelabelAction = "drop"
+	RelabelKeepEqual RelabelAction = "keepequal"
+	RelabelHashMod   RelabelAction = "hashmod"
+	RelabelLabelMap  RelabelAction = "labelmap"
+	RelabelLabelDrop RelabelAction = "labeldrop"
+	RelabelLabelKeep RelabelAction = "labelkeep"
+	RelabelLowercase RelabelAction = "lowercase"
+)
+
+var (
+	regexRelabelTarget = regexp.MustCompile(`^(?:(?:[a-zA-Z_]|\$(?:\{\w+\}|\w+))+\w*)+$`)
+
+	// DefaultRelabelConfig is the default relabel configuration.
+	DefaultRelabelConfig = RelabelConfig{
+		Separator:   ";",
+		Regex:       MustNewRegexp("(.*)"),
+		Replacement: "$1",
+		Action:      RelabelReplace,
+	}
+)
+
+// RelabelConfig configures relabeling of targets with prometheus semantics.
+type RelabelConfig struct {
+	// SourceLabels to concatenate as value.
+	SourceLabels []string `yaml:"source_labels,flow"`
+	// Separator placed between concatenated source label values.
+	Separator string `yaml:"separator"`
+	// Regex against which the value is matched.
+	Regex Regexp `yaml:"regex"`
+	// Modulus to take of the hash of the value.
+	Modulus uint64 `yaml:"modulus"`
+	// TargetLabel to which the resulting value is written.
+	TargetLabel string `yaml:"target_label"`
+	// Replacement to write to target label, may refer to regex groups.
+	Replacement string `yaml:"replacement"`
+	// Action to perform based on regex matching.
+	Action RelabelAction `yaml:"action"`
+}
+
+// Regexp is an anchored regular expression.
+type Regexp struct {
+	*regexp.Regexp
+	original string
+}
+
+// NewRegexp returns an anchored regular expression for str.
+func NewRegexp(str string) (Regexp, error) {
+	re, err := regexp.Compile("^(?:" + str + ")$")
+	return Regexp{Regexp: re, original: str}, err
+}
+
+// MustNewRegexp works like NewRegexp, but panics if str is invalid.
+func MustNewRegexp(str string) Regexp {
+	re, err := NewRegexp(str)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+// String returns the original regular expression.
+func (re Regexp) String() string {
+	return re.original
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (re Regexp) MarshalYAML() (interface{}, error) {
+	return re.original, nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+
+	regex, err := NewRegexp(str)
+	if err != nil {
+		return err
+	}
+	*re = regex
+
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *RelabelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultRelabelConfig
+	type plain RelabelConfig
+
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	switch c.Action {
+	case RelabelReplace, RelabelLowercase:
+		if c.TargetLabel == "" {
+			return fmt.Errorf("relabel action %s requires target_label", c.Action)
+		}
+		if !regexRelabelTarget.MatchString(c.TargetLabel) {
+			return fmt.Errorf("relabel target_label %s must be a valid label name", c.TargetLabel)
+		}
+	case RelabelHashMod:
+		if c.TargetLabel == "" {
+			return fmt.Errorf("relabel action %s requires target_label", c.Action)
+		}
+		if c.Modulus == 0 {
+			return fmt.Errorf("relabel action %s requires modulus greater than 0", c.Action)
+		}
+	case RelabelKeepEqual:
+		if c.TargetLabel == "" || len(c.SourceLabels) == 0 {
+			return fmt.Errorf("relabel action %s requires source_labels and target_label", c.Action)
+		}
+	case RelabelKeep, RelabelDrop, RelabelLabelMap:
+	case RelabelLabelDrop, RelabelLabelKeep:
+		if len(c.SourceLabels) != 0 || c.TargetLabel != "" {
+			return fmt.Errorf("relabel action %s must not set source_labels or target_label", c.Action)
+		}
+	default:
+		return fmt.Errorf("unknown relabel action %s", c.Action)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestLoadRelabelConfig(t *testing.T) {
+	table := map[string]struct {
+		data string
+		ok   bool
+	}{"defaults": {
+		`{target_label: job}`,
+		true,
+	}, "templated target label": {
+		`{source_labels: [__meta_certspotter_id], target_label: "${1}_id"}`,
+		true,
+	}, "hashmod": {
+		`{source_labels: [__address__], target_label: shard, modulus: 4, action: hashmod}`,
+		true,
+	}, "labeldrop": {
+		`{regex: __meta_certspotter_labels_.*, action: labeldrop}`,
+		true,
+	}, "invalid regex": {
+		`{source_labels: [__address__], regex: "(", action: keep}`,
+		false,
+	}, "unknown action": {
+		`{action: remove}`,
+		false,
+	}, "replace without target label": {
+		`{source_labels: [__address__]}`,
+		false,
+	}, "invalid target label": {
+		`{target_label: "0job"}`,
+		false,
+	}, "hashmod without modulus": {
+		`{target_label: shard, action: hashmod}`,
+		false,
+	}, "keepequal without source labels": {
+		`{target_label: shard, action: keepequal}`,
+		false,
+	}, "labelkeep with target label": {
+		`{target_label: shard, action: labelkeep}`,
+		false,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		_, err := Load("files: [{file: targets.json, relabel_configs: [" + test.data + "]}]")
+		if (err == nil) != test.ok {
+			t.Errorf("got: %v want ok: %t", err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package config
+
+//go:generate go test -run TestDescriptions -update
+//go:generate go test -run TestGolden -update
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	// schemaDefaults are the default values of configuration structs.
+	schemaDefaults = map[reflect.Type]interface{}{
+		reflect.TypeOf(GlobalConfig{}):   DefaultGlobalConfig,
+		reflect.TypeOf(AdaptiveConfig{}): DefaultAdaptiveConfig,
+		reflect.TypeOf(DomainConfig{}):   DefaultDomainConfig,
+		reflect.TypeOf(FileConfig{}):     DefaultFileConfig,
+		reflect.TypeOf(RelabelConfig{}):  DefaultRelabelConfig,
+	}
+
+	// schemaRequired are the required properties of configuration structs.
+	schemaRequired = map[reflect.Type][]string{
+		reflect.TypeOf(DomainConfig{}): []string{"domain"},
+		reflect.TypeOf(FileConfig{}):   []string{"file"},
+		reflect.TypeOf(PortConfig{}):   []string{"port"},
+	}
+
+	// schemaTypes are the schemas of types with custom YAML encodings.
+	schemaTypes = map[reflect.Type]map[string]interface{}{
+		reflect.TypeOf(time.Duration(0)): {
+			"type":    "string",
+			"pattern": `^(0|([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+)$`,
+		},
+		reflect.TypeOf(Secret("")): {"type": "string"},
+		reflect.TypeOf(FileMode(0)): {
+			"type":    []string{"string", "integer"},
+			"pattern": "^0?[0-7]{3}$",
+		},
+		reflect.TypeOf(MatchRE{}): {
+			"type":                 "object",
+			"additionalProperties": map[string]interface{}{"type": "string", "format": "regex"},
+		},
+		reflect.TypeOf(Selector{}): {"type": "string"},
+		reflect.TypeOf(Template{}): {"type": []string{"string", "null"}},
+		reflect.TypeOf(Regexp{}):   {"type": "string", "format": "regex"},
+		reflect.TypeOf(RelabelAction("")): {
+			"type": "string",
+			"enum": []RelabelAction{
+				RelabelReplace, RelabelKeep, RelabelDrop, RelabelKeepEqual,
+				RelabelHashMod, RelabelLabelMap, RelabelLabelDrop,
+				RelabelLabelKeep, RelabelLowercase,
+			},
+		},
+	}
+)
+
+// Schema returns the JSON schema of the configuration file generated from
+// the configuration structs, their descriptions and defaults.
+func Schema() ([]byte, error) {
+	defs := make(map[string]interface{})
+	root := schemaObject(reflect.TypeOf(Config{}), defs)
+	root["$schema"] = "http://json-schema.org/draft-07/schema#"
+	root["title"] = "certspotter-sd configuration"
+	root["definitions"] = defs
+
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// schemaOf returns the schema of type t and adds definitions of structs to
+// defs.
+func schemaOf(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	if s, ok := schemaTypes[t]; ok {
+		return s
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), defs)
+	case reflect.Struct:
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = nil
+			defs[t.Name()] = schemaObject(t, defs)
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), defs)}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+// schemaObject returns the schema of struct t with its properties.
+func schemaObject(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	defaults, hasDefaults := schemaDefaults[t]
+	props := make(map[string]interface{})
+	for n := 0; n < t.NumField(); n++ {
+		field := t.Field(n)
+		name := yamlName(field)
+		if name == "" {
+			continue
+		}
+
+		prop := make(map[string]interface{})
+		for key, val := range schemaOf(field.Type, defs) {
+			prop[key] = val
+		}
+		if desc, ok := descriptions[t.Name()+"."+field.Name]; ok {
+			prop["description"] = desc
+		}
+		if hasDefaults {
+			val := reflect.ValueOf(defaults).Field(n)
+			if !val.IsZero() || val.Kind() == reflect.Bool {
+				prop["default"] = schemaValue(val.Interface())
+			}
+		}
+		props[name] = prop
+	}
+
+	obj := map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	if desc, ok := descriptions[t.Name()]; ok {
+		obj["description"] = desc
+	}
+	if required, ok := schemaRequired[t]; ok {
+		obj["required"] = required
+	}
+	return obj
+}
+
+// schemaValue returns val as encoded in YAML.
+func schemaValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case time.Duration:
+		return v.String()
+	case yaml.Marshaler:
+		out, err := v.MarshalYAML()
+		if err != nil {
+			return nil
+		}
+		return out
+	}
+	return val
+}
+
+// yamlName returns the YAML name of field or "" if it isn't encoded.
+func yamlName(field reflect.StructField) string {
+	tag := field.Tag.Get("yaml")
+	name := strings.Split(tag, ",")[0]
+	if tag == "" || name == "-" || field.PkgPath != "" {
+		return ""
+	}
+	return name
+}
+
+// Defaults returns the configuration with defaults applied to a domain and a
+// file.
+func Defaults() *Config {
+	cfg := &Config{}
+	*cfg = DefaultConfig
+
+	dcfg := DefaultDomainConfig
+	dcfg.Domain = "example.com"
+	fcfg := DefaultFileConfig
+	fcfg.File = "targets.json"
+	cfg.DomainConfigs = []*DomainConfig{&dcfg}
+	cfg.FileConfigs = []*FileConfig{&fcfg}
+	return cfg
+}
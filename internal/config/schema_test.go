@@ -0,0 +1,105 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGolden(t *testing.T) {
+	schema, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := map[string][]byte{
+		"schema.json":  schema,
+		"defaults.yml": []byte(Defaults().String()),
+	}
+
+	for name, got := range table {
+		t.Logf("testing: %s", name)
+
+		filename := filepath.Join("testdata", name)
+		if *update {
+			if err := ioutil.WriteFile(filename, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is out of date, run go generate ./internal/config", filename)
+		}
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	want := Defaults()
+	got, err := Load(want.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("got: %s want: %s", got, want)
+	}
+}
+
+func TestSchemaDocumented(t *testing.T) {
+	readme, err := ioutil.ReadFile(filepath.Join("..", "..", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root struct {
+		Properties  map[string]interface{} `json:"properties"`
+		Definitions map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(schema, &root); err != nil {
+		t.Fatal(err)
+	}
+
+	props := []map[string]interface{}{root.Properties}
+	for _, def := range root.Definitions {
+		props = append(props, def.Properties)
+	}
+	for _, prop := range props {
+		for name := range prop {
+			if !strings.Contains(string(readme), name+":") {
+				t.Errorf("got: %s undocumented want: %s in README.md", name, name)
+			}
+		}
+	}
+}
+
+func TestSchemaDescribed(t *testing.T) {
+	for _, typ := range []reflect.Type{
+		reflect.TypeOf(Config{}), reflect.TypeOf(GlobalConfig{}),
+		reflect.TypeOf(DomainConfig{}), reflect.TypeOf(FileConfig{}),
+		reflect.TypeOf(PortConfig{}), reflect.TypeOf(RelabelConfig{}),
+	} {
+		t.Logf("testing: %s", typ.Name())
+
+		for n := 0; n < typ.NumField(); n++ {
+			field := typ.Field(n)
+			if yamlName(field) == "" {
+				continue
+			}
+			if _, ok := descriptions[typ.Name()+"."+field.Name]; !ok {
+				t.Errorf("got: no description want: comment on %s.%s", typ.Name(), field.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+const secretToken = "<secret>"
+
+var (
+	regexEnv = regexp.MustCompile(`\$\$|\$\{([a-zA-Z_][a-zA-Z0-9_]*)(?::-([^}]*))?\}`)
+	// unexpandedKeys are keys whose values are left untouched, as they refer
+	// to regex groups like ${1} or ${name}.
+	unexpandedKeys = map[string]bool{"replacement": true, "target_label": true}
+)
+
+// Secret is a string which is redacted when printed or marshaled.
+type Secret string
+
+// String returns the redacted secret.
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return secretToken
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%q", s.String())), nil
+}
+
+// ExpandEnv replaces ${VAR} and ${VAR:-default} in data by the value of
+// environment variable VAR. The default is used if VAR is unset or empty,
+// $$ escapes a single $. Variables without value or default are an error.
+func ExpandEnv(data string) (string, error) {
+	var missing []string
+	expanded := regexEnv.ReplaceAllStringFunc(data, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+
+		groups := regexEnv.FindStringSubmatch(match)
+		if val := os.Getenv(groups[1]); val != "" {
+			return val
+		}
+		if strings.Contains(match, ":-") {
+			return groups[2]
+		}
+		missing = append(missing, groups[1])
+		return ""
+	})
+
+	if len(missing) != 0 {
+		return "", fmt.Errorf("environment variables %s are not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// expandDocument expands environment variables in the values of the YAML
+// document data. Keys, comments and values of unexpandedKeys are left
+// untouched, expanded values can't change the structure of the document.
+// Unquoted values are resolved after expansion, quoted ones stay strings.
+func expandDocument(data []byte) ([]byte, error) {
+	doc := &yaml3.Node{}
+	if err := yaml3.Unmarshal(data, doc); err != nil {
+		// syntax errors are reported when decoding the document
+		return data, nil
+	}
+	expanded, err := expandNode(doc)
+	if err != nil || !expanded {
+		return data, err
+	}
+	return yaml3.Marshal(doc)
+}
+
+// expandNode expands environment variables in the scalars of node and
+// reports whether any changed.
+func expandNode(node *yaml3.Node) (bool, error) {
+	switch node.Kind {
+	case yaml3.ScalarNode:
+		if !strings.Contains(node.Value, "$") {
+			return false, nil
+		}
+		value, err := ExpandEnv(node.Value)
+		if err != nil || value == node.Value {
+			return false, err
+		}
+		node.Value = value
+		if node.Style == 0 {
+			// plain values are resolved again, e.g. ports from variables
+			node.Tag = ""
+		}
+		return true, nil
+	case yaml3.MappingNode:
+		var expanded bool
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if unexpandedKeys[node.Content[i].Value] {
+				continue
+			}
+			ok, err := expandNode(node.Content[i+1])
+			if err != nil {
+				return false, err
+			}
+			expanded = expanded || ok
+		}
+		return expanded, nil
+	default:
+		var expanded bool
+		for _, child := range node.Content {
+			ok, err := expandNode(child)
+			if err != nil {
+				return false, err
+			}
+			expanded = expanded || ok
+		}
+		return expanded, nil
+	}
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MatchType is the type of a label matcher.
+type MatchType string
+
+// Possible match types of label matchers.
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+var (
+	regexLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*`)
+)
+
+// Matcher matches the value of a single label.
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+// Selector is a set of matchers which all have to match.
+type Selector []*Matcher
+
+// Selectors is a list of selectors of which any has to match.
+type Selectors []Selector
+
+// NewMatcher returns a new matcher for label name.
+func NewMatcher(name string, typ MatchType, value string) (*Matcher, error) {
+	m := &Matcher{Name: name, Type: typ, Value: value}
+	switch typ {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("unknown match type %s", typ)
+	}
+	return m, nil
+}
+
+// Matches returns if matcher matches value.
+func (m *Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+// String returns the matcher in selector syntax.
+func (m *Matcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Type, m.Value)
+}
+
+// ParseSelector parses a selector like {name="value", other!~"regex"}.
+// Braces are optional.
+func ParseSelector(str string) (Selector, error) {
+	in := strings.TrimSpace(str)
+	if strings.HasPrefix(in, "{") {
+		if !strings.HasSuffix(in, "}") {
+			return nil, fmt.Errorf("selector %s misses closing brace", str)
+		}
+		in = strings.TrimSpace(in[1 : len(in)-1])
+	}
+
+	var sel Selector
+	for in != "" {
+		name := regexLabelName.FindString(in)
+		if name == "" {
+			return nil, fmt.Errorf("selector %s: expected label name at %q", str, in)
+		}
+		in = strings.TrimSpace(in[len(name):])
+
+		var typ MatchType
+		for _, t := range []MatchType{MatchRegexp, MatchNotRegexp, MatchNotEqual, MatchEqual} {
+			if strings.HasPrefix(in, string(t)) {
+				typ = t
+				break
+			}
+		}
+		if typ == "" {
+			return nil, fmt.Errorf("selector %s: expected match operator at %q", str, in)
+		}
+		in = strings.TrimSpace(in[len(typ):])
+
+		quoted := quotedPrefix(in)
+		if quoted == "" {
+			return nil, fmt.Errorf("selector %s: expected quoted value at %q", str, in)
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("selector %s: %w", str, err)
+		}
+		in = strings.TrimSpace(in[len(quoted):])
+
+		m, err := NewMatcher(name, typ, value)
+		if err != nil {
+			return nil, fmt.Errorf("selector %s: %w", str, err)
+		}
+		sel = append(sel, m)
+
+		if in != "" {
+			if !strings.HasPrefix(in, ",") {
+				return nil, fmt.Errorf("selector %s: expected comma at %q", str, in)
+			}
+			in = strings.TrimSpace(in[1:])
+		}
+	}
+	return sel, nil
+}
+
+// quotedPrefix returns the double or back quoted string at the start of in or
+// an empty string if there is none.
+func quotedPrefix(in string) string {
+	if in == "" || (in[0] != '"' && in[0] != '`') {
+		return ""
+	}
+	quote := in[0]
+	for i := 1; i < len(in); i++ {
+		switch {
+		case in[i] == '\\' && quote == '"':
+			i++
+		case in[i] == quote:
+			return in[:i+1]
+		}
+	}
+	return ""
+}
+
+// Matches returns if all matchers match labels. Missing labels have an
+// empty value.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, m := range s {
+		if !m.Matches(labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the selector in selector syntax.
+func (s Selector) String() string {
+	strs := make([]string, len(s))
+	for i, m := range s {
+		strs[i] = m.String()
+	}
+	return "{" + strings.Join(strs, ", ") + "}"
+}
+
+// Matches returns if any selector matches labels or no selectors are set.
+func (ss Selectors) Matches(labels map[string]string) bool {
+	if len(ss) == 0 {
+		return true
+	}
+	for _, s := range ss {
+		if s.Matches(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (s Selector) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Selector) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+
+	sel, err := ParseSelector(str)
+	if err != nil {
+		return err
+	}
+	*s = sel
+
+	return nil
+}
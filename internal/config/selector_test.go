@@ -0,0 +1,104 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	table := map[string]struct {
+		str  string
+		want string
+		ok   bool
+	}{"single matcher": {
+		`{__meta_certspotter_issuer_name="CN=R3"}`,
+		`{__meta_certspotter_issuer_name="CN=R3"}`,
+		true,
+	}, "all operators": {
+		`{a="1", b!="2",c=~"3.*" , d!~"4|5"}`,
+		`{a="1", b!="2", c=~"3.*", d!~"4|5"}`,
+		true,
+	}, "without braces": {
+		`team="web"`,
+		`{team="web"}`,
+		true,
+	}, "escaped quotes": {
+		`{a="say \"hi\"", b=~` + "`\\d+`" + `}`,
+		`{a="say \"hi\"", b=~"\\d+"}`,
+		true,
+	}, "empty selector": {
+		`{}`,
+		`{}`,
+		true,
+	}, "missing operator": {
+		`{a "1"}`, "", false,
+	}, "unquoted value": {
+		`{a=1}`, "", false,
+	}, "missing comma": {
+		`{a="1" b="2"}`, "", false,
+	}, "invalid regex": {
+		`{a=~"("}`, "", false,
+	}, "missing brace": {
+		`{a="1"`, "", false,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		got, err := ParseSelector(test.str)
+		if (err == nil) != test.ok {
+			t.Errorf("got: %v want ok: %t", err, test.ok)
+		}
+		if test.ok && got.String() != test.want {
+			t.Errorf("got: %s want: %s", got, test.want)
+		}
+	}
+}
+
+func TestSelectorsMatches(t *testing.T) {
+	labels := map[string]string{
+		"__meta_certspotter_issuer_name":    "C=US, O=Let's Encrypt, CN=R3",
+		"__meta_certspotter_labels_team":    "web",
+		"__meta_certspotter_days_remaining": "12",
+	}
+
+	table := map[string]struct {
+		selectors []string
+		want      bool
+	}{"no selectors": {
+		nil, true,
+	}, "equal": {
+		[]string{`{__meta_certspotter_labels_team="web"}`}, true,
+	}, "not equal": {
+		[]string{`{__meta_certspotter_labels_team!="web"}`}, false,
+	}, "regex is anchored": {
+		[]string{`{__meta_certspotter_issuer_name=~"Let's Encrypt"}`}, false,
+	}, "excluded issuer": {
+		[]string{`{__meta_certspotter_issuer_name!~".*Let's Encrypt.*"}`}, false,
+	}, "missing label is empty": {
+		[]string{`{__meta_certspotter_labels_env=""}`}, true,
+	}, "all matchers": {
+		[]string{`{__meta_certspotter_labels_team="web", __meta_certspotter_days_remaining=~"[0-9]"}`}, false,
+	}, "any selector": {
+		[]string{
+			`{__meta_certspotter_labels_team="shop"}`,
+			`{__meta_certspotter_days_remaining=~"[0-9]|1[0-4]"}`,
+		}, true,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		var sels Selectors
+		for _, str := range test.selectors {
+			sel, err := ParseSelector(str)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sels = append(sels, sel)
+		}
+		if got := sels.Matches(labels); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got: %t want: %t", got, test.want)
+		}
+	}
+}
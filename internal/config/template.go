@@ -0,0 +1,72 @@
+package config
+
+import (
+	"net"
+	"strings"
+	"text/template"
+)
+
+// Template is a text template rendering addresses and label values.
+type Template struct {
+	*template.Template
+	original string
+}
+
+// templateFuncs are functions available in templates.
+var templateFuncs = template.FuncMap{
+	// joinHostPort brackets ipv6 addresses, e.g. {{joinHostPort .Host "443"}}
+	"joinHostPort": net.JoinHostPort,
+}
+
+// NewTemplate parses a text template. Missing map keys render empty.
+func NewTemplate(str string) (*Template, error) {
+	tmpl, err := template.New("").Funcs(templateFuncs).Option("missingkey=zero").Parse(str)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{Template: tmpl, original: str}, nil
+}
+
+// MustNewTemplate works like NewTemplate, but panics if str is invalid.
+func MustNewTemplate(str string) *Template {
+	tmpl, err := NewTemplate(str)
+	if err != nil {
+		panic(err)
+	}
+	return tmpl
+}
+
+// Render executes template with data and returns the result.
+func (t *Template) Render(data interface{}) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// String returns the original template.
+func (t *Template) String() string {
+	return t.original
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (t *Template) MarshalYAML() (interface{}, error) {
+	return t.original, nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (t *Template) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+
+	tmpl, err := NewTemplate(str)
+	if err != nil {
+		return err
+	}
+	*t = *tmpl
+
+	return nil
+}
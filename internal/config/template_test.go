@@ -0,0 +1,47 @@
+package config
+
+import (
+	"io/ioutil"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestTemplateJoinHostPort(t *testing.T) {
+	// the address template of the example configuration must bracket ipv6
+	// addresses
+	data, err := ioutil.ReadFile("../../example/certspotter-sd.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var example struct {
+		Files []struct {
+			AddressTemplate *Template `yaml:"address_template"`
+		} `yaml:"files"`
+	}
+	if err := yaml.Unmarshal(data, &example); err != nil {
+		t.Fatal(err)
+	}
+	if len(example.Files) == 0 || example.Files[0].AddressTemplate == nil {
+		t.Fatalf("got: %+v want: file with address template", example)
+	}
+	tmpl := example.Files[0].AddressTemplate
+
+	table := map[string]string{
+		"www.example.com": "www.example.com:443",
+		"192.0.2.1":       "192.0.2.1:443",
+		"2001:db8::1":     "[2001:db8::1]:443",
+	}
+
+	for host, want := range table {
+		t.Logf("testing: %s", host)
+
+		got, err := tmpl.Render(struct{ Host string }{host})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if got != want {
+			t.Errorf("got: %s want: %s", got, want)
+		}
+	}
+}
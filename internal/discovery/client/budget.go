@@ -0,0 +1,268 @@
+package client
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxStretchedInterval is the longest interval polling intervals are
+	// stretched to for fitting the quota.
+	maxStretchedInterval = 24 * time.Hour
+	// usageBuckets is the number of minutes actual usage is counted for.
+	usageBuckets = 60
+)
+
+// budget projects the api calls per hour of subscriptions and stretches the
+// polling intervals of low priority subscriptions to fit a quota.
+type budget struct {
+	quota   int
+	mtx     sync.Mutex
+	subs    map[*subscription]bool
+	stretch map[int]float64
+	// token is the fingerprint of the token usage is counted for.
+	token   string
+	buckets [usageBuckets]int
+	minute  int64
+	// stop stops refreshing usage once no subscription is left.
+	stop chan struct{}
+}
+
+// subscription is the polling state of a subscription within the budget.
+type subscription struct {
+	interval time.Duration
+	priority int
+	// calls of the last completed incremental poll, at least 1.
+	calls int
+	// polled are the calls of the running poll.
+	polled int
+}
+
+// newBudget returns a budget for quota api calls per hour, unlimited if 0.
+func newBudget(quota int) *budget {
+	return &budget{
+		quota:   quota,
+		subs:    make(map[*subscription]bool),
+		stretch: make(map[int]float64),
+	}
+}
+
+// add adds a subscription polling at interval with priority. A poll is
+// assumed to take a single call until an incremental one was observed.
+func (b *budget) add(interval time.Duration, priority int) *subscription {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	s := &subscription{interval: interval, priority: priority, calls: 1}
+	b.subs[s] = true
+	b.plan()
+	if b.stop == nil {
+		b.stop = make(chan struct{})
+		go b.refresh(b.stop)
+	}
+	return s
+}
+
+// remove removes subscription from the budget.
+func (b *budget) remove(s *subscription) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	delete(b.subs, s)
+	b.plan()
+	if len(b.subs) == 0 && b.stop != nil {
+		close(b.stop)
+		b.stop = nil
+	}
+}
+
+// refresh updates the calls of the last hour every minute until stop is
+// closed, so they decay while no calls are sent.
+func (b *budget) refresh(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			b.mtx.Lock()
+			if b.token != "" {
+				b.advance(now)
+				apiCallsUsedMetric.WithLabelValues(b.token).Set(float64(b.used()))
+			}
+			b.mtx.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// record records a single api call of subscription s, which is nil for
+// calls outside of subscriptions, sent using token.
+func (b *budget) record(s *subscription, token string, now time.Time) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if s != nil {
+		s.polled++
+	}
+	if fp := fingerprint(token); fp != b.token {
+		if b.token != "" {
+			apiCallsProjectedMetric.DeleteLabelValues(b.token)
+			apiCallsUsedMetric.DeleteLabelValues(b.token)
+		}
+		b.token, b.buckets = fp, [usageBuckets]int{}
+		apiCallsProjectedMetric.WithLabelValues(b.token).Set(b.projected())
+	}
+	b.advance(now)
+	b.buckets[b.minute%usageBuckets]++
+	apiCallsUsedMetric.WithLabelValues(b.token).Set(float64(b.used()))
+}
+
+// observe completes a poll of subscription s polling at interval from now
+// on and returns the stretched interval to wait until the next poll. Calls
+// are only observed for incremental polls, as the pages of the initial sync
+// say nothing about later polls.
+func (b *budget) observe(s *subscription, interval time.Duration, incremental bool) time.Duration {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	polled := s.polled
+	if !incremental {
+		polled = 0
+	}
+	if (polled > 0 && polled != s.calls) || interval != s.interval {
+		if polled > 0 {
+			s.calls = polled
+		}
+		s.interval = interval
+		b.plan()
+	}
+	s.polled = 0
+	return b.interval(s)
+}
+
+// advance clears buckets of minutes passed since the last call.
+func (b *budget) advance(now time.Time) {
+	minute := now.Unix() / 60
+	for m := b.minute + 1; m <= minute && m <= b.minute+usageBuckets; m++ {
+		b.buckets[m%usageBuckets] = 0
+	}
+	if minute > b.minute {
+		b.minute = minute
+	}
+}
+
+// used returns the api calls of the last hour.
+func (b *budget) used() int {
+	var n int
+	for _, calls := range b.buckets {
+		n += calls
+	}
+	return n
+}
+
+// interval returns the stretched polling interval of subscription s.
+func (b *budget) interval(s *subscription) time.Duration {
+	f, ok := b.stretch[s.priority]
+	if !ok || f <= 1 {
+		return s.interval
+	}
+	stretched := float64(s.interval) * f
+	if stretched > float64(maxStretchedInterval) {
+		if s.interval > maxStretchedInterval {
+			return s.interval
+		}
+		return maxStretchedInterval
+	}
+	return time.Duration(stretched)
+}
+
+// callRate returns the api calls per hour of subscription s at interval.
+func callRate(s *subscription, interval time.Duration) float64 {
+	return float64(s.calls) * float64(time.Hour) / float64(interval)
+}
+
+// projected returns the api calls per hour at stretched intervals.
+func (b *budget) projected() float64 {
+	var calls float64
+	for s := range b.subs {
+		calls += callRate(s, b.interval(s))
+	}
+	return calls
+}
+
+// plan stretches the intervals of priorities in ascending order until the
+// projected calls fit the quota, each at most to maxStretchedInterval.
+func (b *budget) plan() {
+	b.stretch = make(map[int]float64)
+	defer func() {
+		if b.token != "" {
+			apiCallsProjectedMetric.WithLabelValues(b.token).Set(b.projected())
+		}
+	}()
+	if b.quota == 0 {
+		return
+	}
+
+	demand := make(map[int]float64)
+	var total float64
+	var priorities []int
+	for s := range b.subs {
+		if _, ok := demand[s.priority]; !ok {
+			priorities = append(priorities, s.priority)
+		}
+		demand[s.priority] += callRate(s, s.interval)
+		total += callRate(s, s.interval)
+	}
+	sort.Ints(priorities)
+
+	for _, priority := range priorities {
+		over := total - float64(b.quota)
+		if over <= 0 {
+			return
+		}
+		// intervals are clamped to the maximum if stretching can't suffice
+		f := math.Inf(1)
+		if demand[priority] > over {
+			f = demand[priority] / (demand[priority] - over)
+		}
+		b.stretch[priority] = f
+
+		var stretched float64
+		for s := range b.subs {
+			if s.priority == priority {
+				stretched += callRate(s, b.interval(s))
+			}
+		}
+		total -= demand[priority] - stretched
+	}
+}
+
+// fits returns an error if the projected calls exceed the quota even at the
+// maximum stretched interval.
+func (b *budget) fits() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.quota == 0 {
+		return nil
+	}
+	if projected := math.Round(b.projected()); projected > float64(b.quota) {
+		return fmt.Errorf("projected %.0f api calls per hour exceed quota of %d at intervals of up to %s",
+			projected, b.quota, maxStretchedInterval)
+	}
+	return nil
+}
+
+// fingerprint returns an identifier of token for labeling metrics which
+// doesn't disclose it.
+func fingerprint(token string) string {
+	if token == "" {
+		return "anonymous"
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))[:8]
+}
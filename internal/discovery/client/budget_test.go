@@ -0,0 +1,124 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudgetPlan(t *testing.T) {
+	type sub struct {
+		interval time.Duration
+		priority int
+		calls    int
+	}
+
+	table := map[string]struct {
+		quota int
+		subs  []sub
+		want  []time.Duration
+		fits  bool
+	}{"unlimited": {
+		0,
+		[]sub{{time.Minute, 0, 1}, {time.Minute, 1, 1}},
+		[]time.Duration{time.Minute, time.Minute},
+		true,
+	}, "within quota": {
+		120,
+		[]sub{{time.Minute, 0, 1}, {time.Minute, 1, 1}},
+		[]time.Duration{time.Minute, time.Minute},
+		true,
+	}, "stretch low priority": {
+		90,
+		[]sub{{time.Minute, 0, 1}, {time.Minute, 1, 1}},
+		[]time.Duration{2 * time.Minute, time.Minute},
+		true,
+	}, "stretch by observed calls": {
+		120,
+		[]sub{{time.Minute, 0, 2}, {time.Minute, 1, 1}},
+		[]time.Duration{2 * time.Minute, time.Minute},
+		true,
+	}, "stretch several priorities": {
+		40,
+		[]sub{{time.Minute, 0, 1}, {time.Minute, 1, 1}, {time.Minute, 2, 1}},
+		[]time.Duration{maxStretchedInterval, maxStretchedInterval, 90 * time.Second},
+		true,
+	}, "exceeding quota": {
+		1,
+		[]sub{{time.Minute, 0, 30}, {time.Minute, 1, 30}},
+		[]time.Duration{maxStretchedInterval, maxStretchedInterval},
+		false,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		b := newBudget(test.quota)
+		var subs []*subscription
+		for _, sub := range test.subs {
+			s := b.add(sub.interval, sub.priority)
+			for i := 0; i < sub.calls; i++ {
+				b.record(s, "", time.Now())
+			}
+			b.observe(s, sub.interval, true)
+			subs = append(subs, s)
+		}
+
+		for i, s := range subs {
+			// stretched intervals are rounded to the second
+			if got := b.interval(s).Round(time.Second); got != test.want[i] {
+				t.Errorf("got: %s want: %s", got, test.want[i])
+			}
+		}
+		if err := b.fits(); (err == nil) != test.fits {
+			t.Errorf("got: %v want: fits %t", err, test.fits)
+		}
+	}
+}
+
+func TestBudgetUsage(t *testing.T) {
+	b := newBudget(0)
+	now := time.Unix(3600, 0)
+
+	b.record(nil, "token", now)
+	b.record(nil, "token", now.Add(30*time.Minute))
+	if got := b.used(); got != 2 {
+		t.Errorf("got: %d want: 2", got)
+	}
+
+	b.record(nil, "token", now.Add(70*time.Minute))
+	if got := b.used(); got != 2 {
+		t.Errorf("got: %d want: 2 calls within the last hour", got)
+	}
+
+	b.record(nil, "other", now.Add(71*time.Minute))
+	if got := b.used(); got != 1 || b.token != fingerprint("other") {
+		t.Errorf("got: %d calls of %s want: 1 call of new token", got, b.token)
+	}
+
+	b.advance(now.Add(140 * time.Minute))
+	if got := b.used(); got != 0 {
+		t.Errorf("got: %d want: 0 calls after an hour without calls", got)
+	}
+}
+
+func TestBudgetInitialSync(t *testing.T) {
+	b := newBudget(0)
+	s := b.add(time.Minute, 0)
+
+	// pages of the initial sync are not projected for later polls
+	for i := 0; i < 10; i++ {
+		b.record(s, "", time.Now())
+	}
+	b.observe(s, time.Minute, false)
+	if s.calls != 1 {
+		t.Errorf("got: %d want: 1 call after initial sync", s.calls)
+	}
+
+	b.record(s, "", time.Now())
+	b.record(s, "", time.Now())
+	b.observe(s, time.Minute, true)
+	if s.calls != 2 {
+		t.Errorf("got: %d want: 2 calls of incremental poll", s.calls)
+	}
+	b.remove(s)
+}
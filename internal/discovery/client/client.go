@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -23,60 +24,140 @@ var (
 		},
 		[]string{"endpoint", "method", "status"},
 	)
-	issuancesDiscoveredMetric = promauto.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "certspotter_issuances_discovered_total",
-			Help: "The total number of issuances discovered",
+	apiCallsProjectedMetric = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "certspotter_api_calls_projected_per_hour",
+			Help: "The projected number of api calls per hour at current polling intervals",
+		},
+		[]string{"token"},
+	)
+	apiCallsUsedMetric = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "certspotter_api_calls_last_hour",
+			Help: "The number of api calls sent within the last hour",
+		},
+		[]string{"token"},
+	)
+	pollingIntervalMetric = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "certspotter_domain_polling_interval_seconds",
+			Help: "The current interval between polling for a domain",
 		},
 		[]string{"domain"},
 	)
 )
 
+// Batch represents the issuances received by polling the certspotter api once.
+type Batch struct {
+	// Issuances received since the previous batch.
+	Issuances []*certspotter.Issuance
+	// Err is the error which stopped pagination early, if any.
+	// Issuances of a failed batch are valid but incomplete.
+	Err error
+	// After is the id of the last issuance received so far, polling
+	// resumes after it.
+	After string
+}
+
 // Client is a thin wrapper around certspotter.Client.
 type Client struct {
-	client   *certspotter.Client
-	interval time.Duration
-	limiter  *rate.Limiter
-	logger   *zap.SugaredLogger
+	budget      *budget
+	client      *certspotter.Client
+	interval    time.Duration
+	minInterval time.Duration
+	maxInterval time.Duration
+	limiter     *limiter
+	logger      *zap.SugaredLogger
+	token       func() string
+	mtx         sync.Mutex
+	// intervals are the subscriptions reporting the polling interval of
+	// domains, subscriptions replacing others may start before they stop.
+	intervals map[string]*subscription
 }
 
 // Config is used for configuring the client.
 type Config struct {
 	// Interval used between polling for new issuances.
 	Interval time.Duration
+	// MinInterval and MaxInterval bound polling intervals adapted to the
+	// issuance activity of domains. Intervals aren't adapted if 0.
+	MinInterval time.Duration
+	MaxInterval time.Duration
 	// RateLimit used for sending certspotter api requests in Hz.
 	RateLimit float64
+	// Quota of api calls per hour to stretch polling intervals to, 0 for
+	// unlimited.
+	Quota int
 	// Token used for certspotter api.
 	Token string
+	// TokenFile to read the token from instead, re-read when it changes.
+	TokenFile string
 	// UserAgent used for client agent header.
 	UserAgent string
 }
 
 // NewClient returns a new client for configuration.
 func NewClient(logger *zap.Logger, cfg *Config) *Client {
-	client := certspotter.NewClient(&certspotter.Config{
+	ccfg := &certspotter.Config{
 		Token:     cfg.Token,
 		UserAgent: cfg.UserAgent,
-	})
-	limit := rate.Limit(cfg.RateLimit)
-	limiter := rate.NewLimiter(limit, 5)
+	}
+	token := func() string { return cfg.Token }
+	if cfg.TokenFile != "" {
+		file := &tokenFile{filename: cfg.TokenFile}
+		ccfg.TokenFunc = file.Token
+		token = func() string {
+			token, _ := file.Token()
+			return token
+		}
+	}
+	client := certspotter.NewClient(ccfg)
+	limiter := newLimiter(rate.Limit(cfg.RateLimit), 5)
 
 	return &Client{
-		client:   client,
-		interval: cfg.Interval,
-		limiter:  limiter,
-		logger:   logger.Sugar(),
+		budget:      newBudget(cfg.Quota),
+		client:      client,
+		interval:    cfg.Interval,
+		minInterval: cfg.MinInterval,
+		maxInterval: cfg.MaxInterval,
+		limiter:     limiter,
+		logger:      logger.Sugar(),
+		token:       token,
+		intervals:   make(map[string]*subscription),
 	}
 }
 
+// SubOptions are used for configuring a subscription.
+type SubOptions struct {
+	// Interval used between polling, the interval of the client if 0.
+	Interval time.Duration
+	// Priority of requests contending for the rate limit, higher first.
+	Priority int
+	// MaxInterval bounds adapted intervals below the maximum of the client
+	// if set.
+	MaxInterval time.Duration
+	// Domains to report the polling interval for, the queried domain if
+	// empty.
+	Domains []string
+}
+
 // GetIssuances returns issuances for options.
 // It takes care of rate limiting and pagination.
 func (c *Client) GetIssuances(ctx context.Context, opts *certspotter.GetIssuancesOptions) ([]*certspotter.Issuance, *http.Response, error) {
+	return c.getIssuances(ctx, opts, 0, nil)
+}
+
+// getIssuances returns issuances for options waiting for the rate limit
+// with priority. Calls are recorded in the budget for subscription s.
+func (c *Client) getIssuances(ctx context.Context, opts *certspotter.GetIssuancesOptions, priority int, s *subscription) ([]*certspotter.Issuance, *http.Response, error) {
 	var all []*certspotter.Issuance
 
 	for {
-		c.limiter.Wait(ctx)
+		if err := c.limiter.Wait(ctx, priority); err != nil {
+			return all, nil, err
+		}
 
+		c.budget.record(s, c.token(), time.Now())
 		issuances, resp, err := c.client.GetIssuances(ctx, opts)
 		if resp != nil {
 			apiRequestsMetric.WithLabelValues(
@@ -96,21 +177,45 @@ func (c *Client) GetIssuances(ctx context.Context, opts *certspotter.GetIssuance
 	}
 }
 
-// SubIssuances returns a channel of issuances by subscribing to issuances for options.
-func (c *Client) SubIssuances(ctx context.Context, opts *certspotter.GetIssuancesOptions) <-chan []*certspotter.Issuance {
+// SubIssuances returns a channel of batches by subscribing to issuances for options.
+// Polling resumes after opts.After if set, otherwise it starts with an initial
+// sync of all issuances. With adaptive polling the interval is halved after
+// polls finding new issuances and doubled after quiet ones within the
+// configured bounds.
+func (c *Client) SubIssuances(ctx context.Context, opts *certspotter.GetIssuancesOptions, sub *SubOptions) <-chan *Batch {
 	var delay time.Duration
 	var ok bool
+	synced := opts.After != ""
+
+	interval := c.interval
+	if sub.Interval > 0 {
+		interval = sub.Interval
+	}
+	minInterval, maxInterval := c.minInterval, c.maxInterval
+	if sub.MaxInterval > 0 && sub.MaxInterval < maxInterval {
+		maxInterval = sub.MaxInterval
+	}
+	if minInterval > maxInterval {
+		minInterval = maxInterval
+	}
+	if maxInterval > 0 {
+		interval = bound(interval, minInterval, maxInterval)
+	}
+	domains := sub.Domains
+	if len(domains) == 0 {
+		domains = []string{opts.Domain}
+	}
+	s := c.budget.add(interval, sub.Priority)
 
-	ch := make(chan []*certspotter.Issuance)
+	ch := make(chan *Batch)
 	go func() {
 		defer close(ch)
+		defer c.budget.remove(s)
+		defer c.deleteInterval(s, domains)
 		for {
 			select {
 			case <-time.After(delay):
-				issuances, resp, err := c.GetIssuances(ctx, opts)
-				issuancesDiscoveredMetric.WithLabelValues(
-					opts.Domain,
-				).Add(float64(len(issuances)))
+				issuances, resp, err := c.getIssuances(ctx, opts, sub.Priority, s)
 
 				if err != nil {
 					c.logger.Errorw("getting issuances for domain",
@@ -123,13 +228,23 @@ func (c *Client) SubIssuances(ctx context.Context, opts *certspotter.GetIssuance
 					"issuances", len(issuances),
 				)
 
+				// the initial sync returns all issuances and says nothing
+				// about the current activity or calls of later polls
+				incremental := synced
+				if maxInterval > 0 && incremental && err == nil {
+					interval = adapt(interval, len(issuances), minInterval, maxInterval)
+				}
+				synced = synced || err == nil
+
+				stretched := c.budget.observe(s, interval, incremental)
+				c.setInterval(s, domains, stretched)
 				delay, ok = GetRetryAfter(resp)
-				if !ok || delay < c.interval {
-					delay = c.interval
+				if !ok || delay < stretched {
+					delay = stretched
 				}
 
 				select {
-				case ch <- issuances:
+				case ch <- &Batch{Issuances: issuances, Err: err, After: opts.After}:
 				case <-ctx.Done():
 					return
 				}
@@ -141,6 +256,58 @@ func (c *Client) SubIssuances(ctx context.Context, opts *certspotter.GetIssuance
 	return ch
 }
 
+// setInterval reports interval as polling interval of domains polled by
+// subscription s.
+func (c *Client) setInterval(s *subscription, domains []string, interval time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, domain := range domains {
+		c.intervals[domain] = s
+		pollingIntervalMetric.WithLabelValues(domain).Set(interval.Seconds())
+	}
+}
+
+// deleteInterval removes the polling interval of domains unless another
+// subscription than s reported it since.
+func (c *Client) deleteInterval(s *subscription, domains []string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, domain := range domains {
+		if c.intervals[domain] == s {
+			delete(c.intervals, domain)
+			pollingIntervalMetric.DeleteLabelValues(domain)
+		}
+	}
+}
+
+// adapt returns interval halved if issuances were found or doubled
+// otherwise, bounded by min and max.
+func adapt(interval time.Duration, issuances int, min, max time.Duration) time.Duration {
+	if issuances > 0 {
+		return bound(interval/2, min, max)
+	}
+	return bound(interval*2, min, max)
+}
+
+// bound returns interval bounded by min and max.
+func bound(interval, min, max time.Duration) time.Duration {
+	if interval < min {
+		return min
+	}
+	if interval > max {
+		return max
+	}
+	return interval
+}
+
+// CheckQuota returns an error if the projected api calls of subscriptions
+// exceed the quota even at the longest stretched polling intervals. Until
+// subscriptions completed an incremental poll, each poll is assumed to take
+// a single call.
+func (c *Client) CheckQuota() error {
+	return c.budget.fits()
+}
+
 // GetRetryAfter returns Retry-After duration or false if non could be parsed.
 func GetRetryAfter(resp *http.Response) (time.Duration, bool) {
 	if resp == nil {
@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -162,10 +163,14 @@ func TestClientSubIssuances(t *testing.T) {
 			fmt.Fprint(w, data)
 		})
 
-		ch := cl.SubIssuances(ctx, table[tname].opts)
+		ch := cl.SubIssuances(ctx, table[tname].opts, &SubOptions{})
 		var issuances [][]*certspotter.Issuance
 		for ; idx < num; idx++ {
-			issuances = append(issuances, <-ch)
+			batch := <-ch
+			if batch.Err != nil {
+				t.Errorf("unexpected error: %s", batch.Err)
+			}
+			issuances = append(issuances, batch.Issuances)
 		}
 		return issuances
 	}
@@ -180,6 +185,33 @@ func TestClientSubIssuances(t *testing.T) {
 	}
 }
 
+func TestClientSubIssuancesError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cl, mux, stop := setup()
+	defer stop()
+
+	mux.HandleFunc("/issuances", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("after") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `[{"id":"648494876"}]`)
+	})
+
+	ch := cl.SubIssuances(ctx, &certspotter.GetIssuancesOptions{Domain: "example.com"}, &SubOptions{})
+	batch := <-ch
+
+	want := []*certspotter.Issuance{&certspotter.Issuance{ID: "648494876"}}
+	if !reflect.DeepEqual(batch.Issuances, want) {
+		t.Errorf("got: %v want: %v", batch.Issuances, want)
+	}
+	if !errors.Is(batch.Err, certspotter.ErrUnexpectedStatus) {
+		t.Errorf("got: %v want: %v", batch.Err, certspotter.ErrUnexpectedStatus)
+	}
+}
+
 func TestGetRetryAfter(t *testing.T) {
 	table := map[string]struct {
 		resp *http.Response
@@ -212,3 +244,42 @@ func TestGetRetryAfter(t *testing.T) {
 		}
 	}
 }
+
+func TestAdapt(t *testing.T) {
+	table := map[string]struct {
+		interval  time.Duration
+		issuances int
+		want      time.Duration
+	}{"new issuances": {
+		time.Hour, 3, 30 * time.Minute,
+	}, "quiet domain": {
+		time.Hour, 0, 2 * time.Hour,
+	}, "min interval": {
+		8 * time.Minute, 1, 5 * time.Minute,
+	}, "max interval": {
+		16 * time.Hour, 0, 24 * time.Hour,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		if got := adapt(test.interval, test.issuances, 5*time.Minute, 24*time.Hour); got != test.want {
+			t.Errorf("got: %s want: %s", got, test.want)
+		}
+	}
+}
+
+func TestClientIntervals(t *testing.T) {
+	cl := NewClient(zap.NewNop(), &Config{})
+	replaced, replacing := &subscription{priority: 0}, &subscription{priority: 1}
+
+	// the replacing subscription reports before the replaced one stopped
+	cl.setInterval(replaced, []string{"example.com", "shop.example.com"}, time.Hour)
+	cl.setInterval(replacing, []string{"example.com"}, time.Minute)
+	cl.deleteInterval(replaced, []string{"example.com", "shop.example.com"})
+
+	want := map[string]*subscription{"example.com": replacing}
+	if !reflect.DeepEqual(cl.intervals, want) {
+		t.Errorf("got: %v want: %v", cl.intervals, want)
+	}
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiter is a rate limiter which serves waiters by priority. Waiters of
+// equal priority are served in order of arrival.
+type limiter struct {
+	limiter *rate.Limiter
+	mtx     sync.Mutex
+	waiters waiters
+	seq     uint64
+	running bool
+	// left is signaled when the last waiter left before being served.
+	left chan struct{}
+}
+
+// waiter is waiting for the limiter to allow an event.
+type waiter struct {
+	priority int
+	seq      uint64
+	index    int
+	ready    chan struct{}
+}
+
+// newLimiter returns a priority limiter allowing events up to rate limit and
+// bursts of at most burst events.
+func newLimiter(limit rate.Limit, burst int) *limiter {
+	return &limiter{limiter: rate.NewLimiter(limit, burst), left: make(chan struct{}, 1)}
+}
+
+// Wait blocks until the limiter allows an event for priority or ctx is
+// done. Higher priorities are allowed first.
+func (l *limiter) Wait(ctx context.Context, priority int) error {
+	l.mtx.Lock()
+	w := &waiter{priority: priority, seq: l.seq, ready: make(chan struct{})}
+	l.seq++
+	heap.Push(&l.waiters, w)
+	if !l.running {
+		l.running = true
+		go l.run()
+	}
+	l.mtx.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		if w.index < 0 {
+			// the event was allowed while ctx was done
+			return nil
+		}
+		heap.Remove(&l.waiters, w.index)
+		if l.waiters.Len() == 0 {
+			select {
+			case l.left <- struct{}{}:
+			default:
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+// run allows events to the waiter with the highest priority whenever the
+// rate limit allows one until no waiter is left.
+func (l *limiter) run() {
+	for l.pending() {
+		r := l.limiter.Reserve()
+		if !l.sleep(r.Delay()) {
+			// return the token if all waiters left before it was due
+			r.Cancel()
+			continue
+		}
+
+		l.mtx.Lock()
+		if l.waiters.Len() == 0 {
+			l.running = false
+			l.mtx.Unlock()
+			r.Cancel()
+			return
+		}
+		w := heap.Pop(&l.waiters).(*waiter)
+		close(w.ready)
+		l.mtx.Unlock()
+	}
+}
+
+// sleep waits for delay and returns false if all waiters left before.
+func (l *limiter) sleep(delay time.Duration) bool {
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case <-l.left:
+			l.mtx.Lock()
+			empty := l.waiters.Len() == 0
+			l.mtx.Unlock()
+			if empty {
+				return false
+			}
+		}
+	}
+}
+
+// pending returns if waiters are waiting or stops running otherwise.
+func (l *limiter) pending() bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.waiters.Len() == 0 {
+		l.running = false
+	}
+	return l.running
+}
+
+// waiters is a heap of waiters by priority and arrival.
+type waiters []*waiter
+
+func (ws waiters) Len() int { return len(ws) }
+func (ws waiters) Less(i, j int) bool {
+	if ws[i].priority != ws[j].priority {
+		return ws[i].priority > ws[j].priority
+	}
+	return ws[i].seq < ws[j].seq
+}
+func (ws waiters) Swap(i, j int) {
+	ws[i], ws[j] = ws[j], ws[i]
+	ws[i].index, ws[j].index = i, j
+}
+func (ws *waiters) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*ws)
+	*ws = append(*ws, w)
+}
+func (ws *waiters) Pop() interface{} {
+	old := *ws
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*ws = old[:len(old)-1]
+	return w
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"container/heap"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestLimiterPriority(t *testing.T) {
+	l := newLimiter(rate.Inf, 1)
+	// hold back run until all waiters are queued
+	l.running = true
+
+	priorities := []int{0, 10, 0, 5, 10}
+	order := make(chan int, len(priorities))
+	for n, priority := range priorities {
+		go func(n, priority int) {
+			if err := l.Wait(context.Background(), priority); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			order <- n
+		}(n, priority)
+		// queue waiters in order of arrival
+		for queued(l) != n+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// serve one waiter at a time to observe the order
+	var got []int
+	for range priorities {
+		l.mtx.Lock()
+		w := heap.Pop(&l.waiters).(*waiter)
+		l.mtx.Unlock()
+		close(w.ready)
+		got = append(got, <-order)
+	}
+
+	want := []int{1, 4, 3, 0, 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v want: %v", got, want)
+	}
+}
+
+func TestLimiterRun(t *testing.T) {
+	l := newLimiter(rate.Inf, 1)
+
+	done := make(chan error)
+	for n := 0; n < 3; n++ {
+		go func(priority int) { done <- l.Wait(context.Background(), priority) }(n)
+	}
+	for n := 0; n < 3; n++ {
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := newLimiter(rate.Every(time.Hour), 1)
+	l.limiter.Allow()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- l.Wait(ctx, 0) }()
+	for queued(l) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got: %v want: %v", err, context.Canceled)
+	}
+	if n := queued(l); n != 0 {
+		t.Errorf("got: %d waiters want: 0", n)
+	}
+
+	// the reserved token is returned once run noticed the waiter left
+	for running(l) {
+		time.Sleep(time.Millisecond)
+	}
+	if delay := l.limiter.Reserve().Delay(); delay > time.Hour {
+		t.Errorf("got: delay %s want: at most %s", delay, time.Hour)
+	}
+}
+
+func TestLimiterCancelServed(t *testing.T) {
+	l := newLimiter(rate.Inf, 1)
+	// hold back run to serve the waiter manually
+	l.running = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- l.Wait(ctx, 0) }()
+	for queued(l) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the waiter was served concurrently to ctx being canceled
+	l.mtx.Lock()
+	w := heap.Pop(&l.waiters).(*waiter)
+	close(w.ready)
+	cancel()
+	l.mtx.Unlock()
+
+	if err := <-done; err != nil {
+		t.Errorf("got: %v want: served waiter", err)
+	}
+}
+
+func queued(l *limiter) int {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.waiters.Len()
+}
+
+func running(l *limiter) bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.running
+}
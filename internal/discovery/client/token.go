@@ -0,0 +1,41 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenFile reads a token from file and re-reads it when the file changed.
+type tokenFile struct {
+	filename string
+	mtx      sync.Mutex
+	modTime  time.Time
+	size     int64
+	token    string
+}
+
+// Token returns the current token of file.
+func (f *tokenFile) Token() (string, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	info, err := os.Stat(f.filename)
+	if err != nil {
+		return "", fmt.Errorf("reading token file: %w", err)
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size && f.token != "" {
+		return f.token, nil
+	}
+
+	data, err := ioutil.ReadFile(f.filename)
+	if err != nil {
+		return "", fmt.Errorf("reading token file: %w", err)
+	}
+	f.token = strings.TrimSpace(string(data))
+	f.modTime, f.size = info.ModTime(), info.Size()
+	return f.token, nil
+}
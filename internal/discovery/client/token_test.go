@@ -0,0 +1,38 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certspotter-sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "token")
+	f := &tokenFile{filename: filename}
+
+	if _, err := f.Token(); err == nil {
+		t.Errorf("got: no error want: error for missing file")
+	}
+
+	for _, token := range []string{"first", "rotated"} {
+		t.Logf("testing: %s", token)
+
+		if err := ioutil.WriteFile(filename, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := f.Token()
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if got != token {
+			t.Errorf("got: %s want: %s", got, token)
+		}
+	}
+}
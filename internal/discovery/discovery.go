@@ -2,8 +2,12 @@ package discovery
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"reflect"
 	"sync"
 	"time"
 
@@ -15,8 +19,11 @@ import (
 	"github.com/codecentric/certspotter-sd/internal/certspotter"
 	"github.com/codecentric/certspotter-sd/internal/config"
 	"github.com/codecentric/certspotter-sd/internal/discovery/client"
+	"github.com/codecentric/certspotter-sd/internal/discovery/file"
+	"github.com/codecentric/certspotter-sd/internal/discovery/index"
 	"github.com/codecentric/certspotter-sd/internal/discovery/target"
 	"github.com/codecentric/certspotter-sd/internal/version"
+	"github.com/codecentric/certspotter-sd/internal/zone"
 )
 
 var (
@@ -26,6 +33,43 @@ var (
 			Help: "The current number of targets from issuances",
 		},
 	)
+	issuancesStoredMetric = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "certspotter_issuances_stored",
+			Help: "The current number of issuances stored in memory",
+		},
+	)
+	issuancesBytesMetric = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "certspotter_issuances_stored_bytes",
+			Help: "The approximate size of issuances stored in memory",
+		},
+	)
+	internedStringsMetric = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "certspotter_interned_strings",
+			Help: "The current number of interned strings",
+		},
+	)
+	internedBytesMetric = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "certspotter_interned_strings_bytes",
+			Help: "The size of interned strings",
+		},
+	)
+	wildcardsUncoveredMetric = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "certspotter_wildcard_certificates_uncovered",
+			Help: "The number of valid wildcard certificates covering no host",
+		},
+	)
+	zoneMissingMetric = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "certspotter_zone_missing_certificates",
+			Help: "The number of valid certificates with names missing from zone",
+		},
+		[]string{"zone"},
+	)
 	targetsWrittenMetric = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "certspotter_targets_written",
@@ -33,156 +77,617 @@ var (
 		},
 		[]string{"filename"},
 	)
+	fileWritesMetric = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "certspotter_file_writes_total",
+			Help: "The total number of writes performed per file",
+		},
+		[]string{"filename"},
+	)
+	fileWritesSkippedMetric = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "certspotter_file_writes_skipped_total",
+			Help: "The total number of writes skipped per file",
+		},
+		[]string{"filename", "reason"},
+	)
+	fileLastWriteMetric = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "certspotter_file_last_write_timestamp_seconds",
+			Help: "The timestamp of the last write per file",
+		},
+		[]string{"filename"},
+	)
+	targetsDroppedMetric = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "certspotter_targets_dropped",
+			Help: "The current number of targets dropped per file as their templates failed to render or rendered empty addresses",
+		},
+		[]string{"filename"},
+	)
+	fileSizeMetric = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "certspotter_file_size_bytes",
+			Help: "The size of the last write per file",
+		},
+		[]string{"filename"},
+	)
+	domainStaleMetric = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "certspotter_domain_stale",
+			Help: "Whether issuances of a domain are stale (1) or in sync (0)",
+		},
+		[]string{"domain"},
+	)
+	domainLastSyncMetric = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "certspotter_domain_last_sync_timestamp_seconds",
+			Help: "The timestamp of the last successful sync of a domain",
+		},
+		[]string{"domain"},
+	)
+	issuancesDiscoveredMetric = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "certspotter_issuances_discovered_total",
+			Help: "The total number of issuances discovered",
+		},
+		[]string{"domain"},
+	)
+	apiCallsSavedMetric = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "certspotter_api_calls_saved",
+			Help: "The number of api calls saved per polling cycle by collapsing covered domains",
+		},
+	)
 )
 
 // Discovery is used for exporting issuances as targets to file.
 type Discovery struct {
-	client    *client.Client
-	cfg       *config.Config
-	issuances []*certspotter.Issuance
-	logger    *zap.SugaredLogger
-	mtx       sync.RWMutex
-	send      chan struct{}
+	client   *client.Client
+	cfg      *config.Config
+	ctx      context.Context
+	domains  []*domain
+	index    *index.Index
+	interval time.Duration
+	logger   *zap.SugaredLogger
+	mtx      sync.RWMutex
+	send     chan struct{}
+	reloaded chan struct{}
+	written  map[string]*written
+	// dropped are the targets dropped per file at the last write.
+	dropped map[string]int
+}
+
+// written holds the state of the last write of a file.
+type written struct {
+	targets int
+	sum     [sha256.Size]byte
+}
+
+// domain holds the sync state of a single domain.
+type domain struct {
+	cfg *config.DomainConfig
+	// cancel stops the subscription of domain.
+	cancel context.CancelFunc
+	// query is the key of the query domain is subscribed with.
+	query string
+	// covering is the domain whose query collects issuances of domain.
+	covering *domain
+	// after is the id of the last issuance collected for domain, queries
+	// of domain resume after it.
+	after string
+	// synced is set once pagination completed without errors.
+	synced bool
+	// failed is set if the last batch stopped because of an error.
+	failed bool
+}
+
+// Stale returns if issuances of domain are incomplete or outdated.
+func (d *domain) Stale() bool {
+	return !d.synced || d.failed
 }
 
 // NewDiscovery returns a new discovery form global configuration.
 func NewDiscovery(logger *zap.Logger, cfg *config.Config) *Discovery {
+	var domains []*domain
+	for _, dcfg := range cfg.DomainConfigs {
+		domains = append(domains, &domain{cfg: dcfg})
+	}
+	var minInterval, maxInterval time.Duration
+	if adaptive := cfg.GlobalConfig.AdaptivePolling; adaptive != nil {
+		minInterval, maxInterval = adaptive.MinInterval, adaptive.MaxInterval
+	}
+
 	return &Discovery{
-		cfg: cfg,
+		cfg:     cfg,
+		domains: domains,
+		index:   index.NewIndex(cfg.FileConfigs, indexOptions(cfg)),
+		// changes of the global polling interval require a restart
+		interval: cfg.GlobalConfig.Interval,
 		client: client.NewClient(logger, &client.Config{
-			Interval:  cfg.GlobalConfig.Interval,
-			RateLimit: cfg.GlobalConfig.RateLimit,
-			Token:     cfg.GlobalConfig.Token,
-			UserAgent: version.UserAgent(),
+			Interval:    cfg.GlobalConfig.Interval,
+			MinInterval: minInterval,
+			MaxInterval: maxInterval,
+			RateLimit:   cfg.GlobalConfig.RateLimit,
+			Quota:       cfg.GlobalConfig.Quota,
+			Token:       string(cfg.GlobalConfig.Token),
+			TokenFile:   cfg.GlobalConfig.TokenFile,
+			UserAgent:   version.UserAgent(),
 		}),
-		logger: logger.Sugar(),
+		logger:   logger.Sugar(),
+		send:     make(chan struct{}, 1),
+		reloaded: make(chan struct{}, 1),
+		written:  make(map[string]*written),
+		dropped:  make(map[string]int),
 	}
 }
 
 // Discover discovers prometheus targets from certificate issuances and writes
-// all valif targets to files.
-func (d *Discovery) Discover(ctx context.Context) {
+// all valif targets to files. It returns once ctx is done and the final
+// export finished with the error of the final export.
+func (d *Discovery) Discover(ctx context.Context) error {
 	d.logger.Infow("starting discovering issuances")
 
-	var chans []<-chan []*certspotter.Issuance
-	for _, cfg := range d.cfg.DomainConfigs {
-		d.logger.Infow("subscribing to issuances", "domain", cfg.Domain)
-		in := d.client.SubIssuances(ctx, &certspotter.GetIssuancesOptions{
-			Domain:            cfg.Domain,
-			Expand:            []string{"cert", "dns_names", "issuer"},
-			IncludeSubdomains: cfg.IncludeSubdomains,
-		})
-		chans = append(chans, in)
+	d.mtx.Lock()
+	d.ctx = ctx
+	d.replan()
+	if err := d.client.CheckQuota(); err != nil {
+		d.logger.Warnw("configuration can't fit api quota", "err", err)
+	}
+	for _, cfg := range d.cfg.FileConfigs {
+		d.restore(cfg.File)
+	}
+	d.mtx.Unlock()
+
+	return d.export(ctx)
+}
+
+// Reload applies configuration to a running discovery. Subscriptions are
+// only started or stopped for added or removed domains and restarted for
+// queries whose covered domains, polling interval or priority changed.
+// Restarted queries resume after their last issuance. Issuances of kept
+// domains are retained and file configurations are re-applied at once.
+func (d *Discovery) Reload(cfg *config.Config) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	old, next := d.cfg.GlobalConfig, cfg.GlobalConfig
+	if old.Interval != next.Interval || old.RateLimit != next.RateLimit || old.Quota != next.Quota ||
+		old.Token != next.Token || old.TokenFile != next.TokenFile ||
+		!reflect.DeepEqual(old.AdaptivePolling, next.AdaptivePolling) {
+		d.logger.Warnw("changes of polling interval, adaptive polling, rate limit, quota and token require a restart")
 	}
 
-	d.send = make(chan struct{})
-	defer close(d.send)
+	running := make(map[subscription]*domain)
+	for _, dom := range d.domains {
+		running[subscriptionOf(dom.cfg)] = dom
+	}
+
+	var domains []*domain
+	mapping := make(map[*config.DomainConfig]*config.DomainConfig)
+	for _, dcfg := range cfg.DomainConfigs {
+		key := subscriptionOf(dcfg)
+		if dom, ok := running[key]; ok {
+			delete(running, key)
+			mapping[dom.cfg] = dcfg
+			dom.cfg = dcfg
+			domains = append(domains, dom)
+			continue
+		}
+		domains = append(domains, &domain{cfg: dcfg})
+	}
+	for _, dom := range running {
+		d.logger.Infow("unsubscribing from issuances", "domain", dom.cfg.Domain)
+		if dom.cancel != nil {
+			dom.cancel()
+		}
+		mapping[dom.cfg] = nil
+		domainStaleMetric.DeleteLabelValues(dom.cfg.Domain)
+		domainLastSyncMetric.DeleteLabelValues(dom.cfg.Domain)
+	}
+
+	files := make(map[string]bool)
+	for _, fcfg := range cfg.FileConfigs {
+		files[fcfg.File] = true
+		if _, ok := d.written[fcfg.File]; !ok {
+			d.restore(fcfg.File)
+		}
+	}
+	for filename := range d.written {
+		if !files[filename] {
+			delete(d.written, filename)
+			delete(d.dropped, filename)
+			targetsWrittenMetric.DeleteLabelValues(filename)
+			targetsDroppedMetric.DeleteLabelValues(filename)
+			fileLastWriteMetric.DeleteLabelValues(filename)
+			fileSizeMetric.DeleteLabelValues(filename)
+		}
+	}
+
+	d.cfg, d.domains = cfg, domains
+	d.index.Reload(cfg.FileConfigs, mapping, indexOptions(cfg))
+	d.replan()
+
+	select {
+	case d.reloaded <- struct{}{}:
+	default:
+	}
+}
 
-	for _, ch := range chans {
-		go d.collect(ctx, ch)
+// indexOptions returns the index options of configuration.
+func indexOptions(cfg *config.Config) *index.Options {
+	opts := &index.Options{StaleLabel: cfg.GlobalConfig.StaleLabel}
+	if len(cfg.Zones) != 0 {
+		opts.Zones = zone.NewInventory(cfg.Zones)
 	}
-	d.export(ctx)
+	return opts
+}
+
+// subscription identifies the certspotter api subscription of a domain.
+type subscription struct {
+	domain            string
+	includeSubdomains bool
+}
+
+// subscriptionOf returns the subscription of domain configuration.
+func subscriptionOf(cfg *config.DomainConfig) subscription {
+	return subscription{domain: cfg.Domain, includeSubdomains: cfg.IncludeSubdomains}
 }
 
-// collect collects issuances from channel to internal structure.
-func (d *Discovery) collect(ctx context.Context, ch <-chan []*certspotter.Issuance) {
+// replan plans the queries of domains. Subscriptions of outdated queries are
+// stopped and missing ones are started once discovering. It must be called
+// with the lock held.
+func (d *Discovery) replan() {
+	var saved int
+	queries := plan(d.domains, d.interval)
+	keys := make(map[*domain]string)
+	for _, q := range queries {
+		keys[q.domain] = q.key()
+		saved += len(q.covered)
+	}
+	apiCallsSavedMetric.Set(float64(saved))
+
+	for _, dom := range d.domains {
+		if dom.cancel != nil && dom.query != keys[dom] {
+			dom.cancel()
+			dom.cancel, dom.query = nil, ""
+		}
+	}
+	if d.ctx == nil {
+		return
+	}
+	for _, q := range queries {
+		if q.domain.cancel == nil {
+			d.subscribe(q)
+		}
+	}
+}
+
+// subscribe starts collecting issuances of query. Queries resume after the
+// last issuance collected for their domain, covered domains newly collected
+// by the query are backfilled with the issuances of its domain. It must be
+// called with the lock held.
+func (d *Discovery) subscribe(q *query) {
+	var ctx context.Context
+	dom := q.domain
+	ctx, dom.cancel = context.WithCancel(d.ctx)
+	dom.query = q.key()
+
+	opts := &certspotter.GetIssuancesOptions{
+		Domain:            dom.cfg.Domain,
+		Expand:            []string{"cert", "dns_names", "issuer"},
+		IncludeSubdomains: dom.cfg.IncludeSubdomains,
+		After:             dom.after,
+	}
+	for _, member := range q.members() {
+		if opts.After != "" && member.covering != dom && member != dom {
+			cfg := member.cfg
+			d.index.Backfill(dom.cfg, cfg, func(names []string) bool { return routes(cfg, names) })
+		}
+		member.covering = dom
+	}
+
+	d.logger.Infow("subscribing to issuances",
+		"domain", dom.cfg.Domain,
+		"covered", len(q.covered),
+		"after", opts.After,
+	)
+	ch := d.client.SubIssuances(ctx, opts, q.opts)
+	for _, member := range q.members() {
+		domainStaleMetric.WithLabelValues(member.cfg.Domain).Set(btof(member.Stale()))
+	}
+	go d.collect(ctx, q, ch)
+}
+
+// restore restores the state of the last write of filename from disk. It
+// must be called with the lock held.
+func (d *Discovery) restore(filename string) {
+	if data, err := ioutil.ReadFile(filename); err == nil {
+		d.written[filename] = &written{
+			targets: Count(data),
+			sum:     sha256.Sum256(data),
+		}
+		fileSizeMetric.WithLabelValues(filename).Set(float64(len(data)))
+	}
+}
+
+// collect collects batches from channel into the domains of query until ctx
+// is done or the query was replaced.
+func (d *Discovery) collect(ctx context.Context, q *query, ch <-chan *client.Batch) {
+	members := q.members()
 	for {
 		select {
-		case issuances := <-ch:
-			d.mtx.RLock()
-			d.issuances = append(d.issuances, issuances...)
-			d.mtx.RUnlock()
-			d.send <- struct{}{}
+		case batch, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			d.mtx.Lock()
+			if ctx.Err() != nil {
+				d.mtx.Unlock()
+				return
+			}
+			names := make([]string, len(members))
+			stale := make([]bool, len(members))
+			discovered := make([]int, len(members))
+			for i, dom := range members {
+				issuances := batch.Issuances
+				if dom != q.domain {
+					issuances = route(dom.cfg, issuances)
+				}
+				discovered[i] = len(issuances)
+				dom.failed = batch.Err != nil
+				dom.synced = dom.synced || !dom.failed
+				if batch.After != "" {
+					dom.after = batch.After
+				}
+				names[i], stale[i] = dom.cfg.Domain, dom.Stale()
+				d.index.Add(dom.cfg, issuances)
+				d.index.SetStale(dom.cfg, stale[i])
+			}
+			d.mtx.Unlock()
+
+			for i, name := range names {
+				issuancesDiscoveredMetric.WithLabelValues(name).Add(float64(discovered[i]))
+				if batch.Err == nil {
+					domainLastSyncMetric.WithLabelValues(name).SetToCurrentTime()
+				}
+				domainStaleMetric.WithLabelValues(name).Set(btof(stale[i]))
+			}
+			d.notify()
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-// export writes issuances as targets to files.
-func (d *Discovery) export(ctx context.Context) {
-	write := func() {
-		tgs := GetTargets(d.issuances)
-		d.logger.Debugw("got targets from issuances",
-			"targets", len(tgs),
-			"issuances", len(d.issuances),
-		)
-		targetsDiscoveredMetric.Set(float64(len(tgs)))
+// notify signals export that issuances changed without blocking.
+func (d *Discovery) notify() {
+	select {
+	case d.send <- struct{}{}:
+	default:
+	}
+}
 
-		for filename, tgs := range GetFileTargets(tgs, d.cfg.FileConfigs) {
-			d.logger.Debugw("writing targets to file",
-				"filename", filename,
-				"targets", len(tgs),
-			)
-			if err := Write(filename, tgs); err != nil {
-				d.logger.Errorw("writing targets to file",
-					"filename", filename,
-					"err", err,
-				)
-			}
-			targetsWrittenMetric.WithLabelValues(
-				filename,
-			).Set(float64(len(tgs)))
+// synced returns if all domains finished their initial sync.
+func (d *Discovery) synced() bool {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	for _, dom := range d.domains {
+		if !dom.synced {
+			return false
 		}
 	}
+	return true
+}
+
+// export writes issuances as targets to files once all domains are synced
+// or the initial sync timed out. Changes are coalesced within the export
+// debounce window. Pending changes are flushed once ctx is done.
+func (d *Discovery) export(ctx context.Context) error {
+	var ready bool
+	var debounce <-chan time.Time
+	cfg := d.global()
+	timeout := time.NewTimer(cfg.InitialSyncTimeout)
+	defer timeout.Stop()
 
-	ticker := time.NewTicker(time.Minute * 5)
+	ticker := time.NewTicker(cfg.ExportInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			write()
 		case <-d.send:
-			write()
+			if debounce == nil {
+				debounce = time.After(d.global().ExportDebounce)
+			}
+			continue
+		case <-debounce:
+			debounce = nil
+		case <-d.reloaded:
+			ticker.Reset(d.global().ExportInterval)
+		case <-timeout.C:
+			if !ready {
+				d.logger.Warnw("initial sync timed out, exporting stale targets",
+					"timeout", cfg.InitialSyncTimeout,
+				)
+				ready = true
+			}
 		case <-ctx.Done():
-			return
+			if !ready {
+				return nil
+			}
+			d.logger.Infow("flushing targets before shutdown")
+			return d.write()
+		}
+
+		if !ready && d.synced() {
+			d.logger.Infow("initial sync finished")
+			ready = true
+		}
+		if ready {
+			d.write()
 		}
 	}
 }
 
-// GetTargets returns a set of valid targtes from issuances
-func GetTargets(issuances []*certspotter.Issuance) []*target.Target {
-	now := time.Now()
-	var tgs []*target.Target
-	for _, issuance := range issuances {
-		if now.After(issuance.NotAfter) || now.Before(issuance.NotBefore) {
+// global returns the current global configuration.
+func (d *Discovery) global() config.GlobalConfig {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+	return d.cfg.GlobalConfig
+}
+
+// rendered holds targets rendered for a file and its last write.
+type rendered struct {
+	data    []byte
+	targets int
+	last    *written
+	// dropped targets and an error of them as example.
+	dropped int
+	err     error
+}
+
+// write writes current targets to files. Files are not shrunk while any
+// domain is stale to keep the last known good targets and are skipped if
+// their content is unchanged. It returns the first error writing files.
+func (d *Discovery) write() error {
+	var werr error
+	var stale bool
+	var filenames []string
+	cfgs := make(map[string]*config.FileConfig)
+	files := make(map[string]*rendered)
+
+	d.mtx.Lock()
+	d.index.Update(time.Now())
+	stats := d.index.Stats()
+	zones := d.cfg.Zones
+	for _, dom := range d.domains {
+		stale = stale || dom.Stale()
+	}
+	for _, cfg := range d.cfg.FileConfigs {
+		if _, ok := cfgs[cfg.File]; ok {
 			continue
 		}
-		tgs = append(tgs, target.NewTarget(issuance))
+		data, n := d.index.Render(cfg.File)
+		dropped, err := d.index.Dropped(cfg.File)
+		cfgs[cfg.File] = cfg
+		files[cfg.File] = &rendered{
+			data: data, targets: n, last: d.written[cfg.File],
+			dropped: dropped, err: err,
+		}
+		filenames = append(filenames, cfg.File)
 	}
-	return tgs
-}
+	d.mtx.Unlock()
 
-// GetFileTargets returns a map of targets per matching file
-func GetFileTargets(tgs []*target.Target, cfgs []*config.FileConfig) map[string][]*target.Target {
-	files := make(map[string][]*target.Target)
-	for _, cfg := range cfgs {
-		files[cfg.File] = []*target.Target{}
+	d.logger.Debugw("got targets from issuances",
+		"targets", stats.Targets,
+		"issuances", stats.Issuances,
+	)
+	targetsDiscoveredMetric.Set(float64(stats.Targets))
+	issuancesStoredMetric.Set(float64(stats.Issuances))
+	issuancesBytesMetric.Set(float64(stats.Bytes))
+	internedStringsMetric.Set(float64(stats.Strings))
+	internedBytesMetric.Set(float64(stats.StringBytes))
+	wildcardsUncoveredMetric.Set(float64(stats.Uncovered))
+	zoneMissingMetric.Reset()
+	for _, z := range zones {
+		zoneMissingMetric.WithLabelValues(z.Origin).Set(float64(stats.Missing[z.Origin]))
 	}
 
-	for _, tg := range tgs {
-		for _, cfg := range cfgs {
-			if !tg.Matches(cfg.MatchRE) {
-				continue
+	for _, filename := range filenames {
+		cfg, out := cfgs[filename], files[filename]
+		d.reportDropped(filename, out)
+		last, ok := out.last, out.last != nil
+		if !ok {
+			last = &written{}
+		}
+
+		if stale && out.targets < last.targets {
+			d.logger.Warnw("keeping last known good targets of stale domains",
+				"filename", filename,
+				"targets", out.targets,
+				"written", last.targets,
+			)
+			fileWritesSkippedMetric.WithLabelValues(filename, "stale").Inc()
+			continue
+		}
+
+		sum := sha256.Sum256(out.data)
+		if ok && sum == last.sum {
+			fileWritesSkippedMetric.WithLabelValues(filename, "unchanged").Inc()
+			continue
+		}
+
+		d.logger.Debugw("writing targets to file",
+			"filename", filename,
+			"targets", out.targets,
+		)
+		if err := Write(filename, out.data, cfg); err != nil {
+			d.logger.Errorw("writing targets to file",
+				"filename", filename,
+				"err", err,
+			)
+			if werr == nil {
+				werr = fmt.Errorf("writing targets to file %s: %w", filename, err)
 			}
-			tg.AddLabels(cfg.Labels)
-			files[cfg.File] = append(files[cfg.File], tg)
+			continue
 		}
+		d.mtx.Lock()
+		d.written[filename] = &written{targets: out.targets, sum: sum}
+		d.mtx.Unlock()
+		fileWritesMetric.WithLabelValues(filename).Inc()
+		fileLastWriteMetric.WithLabelValues(filename).SetToCurrentTime()
+		fileSizeMetric.WithLabelValues(filename).Set(float64(len(out.data)))
+		targetsWrittenMetric.WithLabelValues(
+			filename,
+		).Set(float64(out.targets))
+	}
+	return werr
+}
+
+// reportDropped exports the targets of filename dropped while rendering and
+// logs them whenever their number changed.
+func (d *Discovery) reportDropped(filename string, out *rendered) {
+	d.mtx.Lock()
+	changed := d.dropped[filename] != out.dropped
+	d.dropped[filename] = out.dropped
+	d.mtx.Unlock()
+
+	targetsDroppedMetric.WithLabelValues(filename).Set(float64(out.dropped))
+	if changed && out.dropped != 0 {
+		d.logger.Warnw("dropping targets whose templates failed to render",
+			"filename", filename,
+			"dropped", out.dropped,
+			"err", out.err,
+		)
 	}
-	return files
 }
 
-// Write writes targets as json array to filename
-func Write(filename string, tgs []*target.Target) error {
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return err
+// Write writes rendered targets to file of configuration.
+func Write(filename string, data []byte, cfg *config.FileConfig) error {
+	return file.Write(filename, data, &file.Options{
+		Mode:       os.FileMode(cfg.Mode),
+		UID:        cfg.UID,
+		GID:        cfg.GID,
+		CreateDirs: cfg.CreateDirs,
+	})
+}
+
+// Count returns the number of targets in rendered targets.
+func Count(data []byte) int {
+	var tgs []*target.Target
+	if err := json.Unmarshal(data, &tgs); err != nil {
+		return 0
 	}
-	defer file.Close()
+	return len(tgs)
+}
 
-	if tgs == nil {
-		tgs = []*target.Target{}
+// btof converts a bool to a float64 metric value.
+func btof(b bool) float64 {
+	if b {
+		return 1
 	}
-	return json.NewEncoder(file).Encode(tgs)
+	return 0
 }
@@ -1,11 +1,24 @@
 package discovery
 
 import (
-	"reflect"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"go.uber.org/zap"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
 	"github.com/codecentric/certspotter-sd/internal/certspotter"
+	"github.com/codecentric/certspotter-sd/internal/config"
+	"github.com/codecentric/certspotter-sd/internal/discovery/client"
+	"github.com/codecentric/certspotter-sd/internal/discovery/index"
 	"github.com/codecentric/certspotter-sd/internal/discovery/target"
 )
 
@@ -17,100 +30,327 @@ func mustParseTime(str string) time.Time {
 	return time
 }
 
-func TestGetTargets(t *testing.T) {
+func TestDiscoveryWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certspotter-sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "targets.json")
+	valid := &certspotter.Issuance{
+		ID:        "648494876",
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}
+
 	table := map[string]struct {
-		issuances []*certspotter.Issuance
-		want      []*target.Target
-	}{"valid issuances": {
-		[]*certspotter.Issuance{
-			&certspotter.Issuance{
-				ID:          "648494876",
-				NotBefore:   mustParseTime("2000-01-01T00:00:00-00:00"),
-				NotAfter:    mustParseTime("2100-01-01T00:00:00-00:00"),
-				Certificate: &certspotter.Certificate{Type: "cert"},
-			},
-			&certspotter.Issuance{
-				ID:          "648494877",
-				NotBefore:   mustParseTime("2000-01-01T00:00:00-00:00"),
-				NotAfter:    mustParseTime("2100-01-01T00:00:00-00:00"),
-				Certificate: &certspotter.Certificate{Type: "precert"},
-			},
-		},
-		[]*target.Target{
-			&target.Target{Labels: map[string]string{
-				"__meta_certspotter_id":          "648494876",
-				"__meta_certspotter_cert_sha256": "",
-				"__meta_certspotter_cert_type":   "cert",
-			}},
-			&target.Target{Labels: map[string]string{
-				"__meta_certspotter_id":          "648494877",
-				"__meta_certspotter_cert_sha256": "",
-				"__meta_certspotter_cert_type":   "precert",
-			}},
-		},
-	}, "precert issuances": {
-		[]*certspotter.Issuance{
-			&certspotter.Issuance{
-				ID:          "648494876",
-				NotBefore:   mustParseTime("2000-01-01T00:00:00-00:00"),
-				NotAfter:    mustParseTime("2100-01-01T00:00:00-00:00"),
-				Certificate: &certspotter.Certificate{Type: "cert"},
-			},
-			&certspotter.Issuance{
-				ID:          "648494877",
-				NotBefore:   mustParseTime("2000-01-01T00:00:00-00:00"),
-				NotAfter:    mustParseTime("2100-01-01T00:00:00-00:00"),
-				Certificate: &certspotter.Certificate{Type: "precert"},
-			},
-		},
-		[]*target.Target{
-			&target.Target{Labels: map[string]string{
-				"__meta_certspotter_id":          "648494876",
-				"__meta_certspotter_cert_sha256": "",
-				"__meta_certspotter_cert_type":   "cert",
-			}},
-			&target.Target{Labels: map[string]string{
-				"__meta_certspotter_id":          "648494877",
-				"__meta_certspotter_cert_sha256": "",
-				"__meta_certspotter_cert_type":   "precert",
-			}},
-		},
-	}, "outdated issuances": {
-		[]*certspotter.Issuance{
-			&certspotter.Issuance{
-				ID:          "648494876",
-				NotBefore:   mustParseTime("2000-01-01T00:00:00-00:00"),
-				NotAfter:    mustParseTime("2100-01-01T00:00:00-00:00"),
-				Certificate: &certspotter.Certificate{Type: "cert"},
-			},
-			&certspotter.Issuance{
-				ID:          "648494877",
-				NotBefore:   mustParseTime("2000-01-01T00:00:00-00:00"),
-				NotAfter:    mustParseTime("2000-01-01T00:00:00-00:00"),
-				Certificate: &certspotter.Certificate{Type: "cert"},
-			},
-			&certspotter.Issuance{
-				ID:          "648494877",
-				NotBefore:   mustParseTime("2100-01-01T00:00:00-00:00"),
-				NotAfter:    mustParseTime("2100-01-01T00:00:00-00:00"),
-				Certificate: &certspotter.Certificate{Type: "cert"},
-			},
-		},
-		[]*target.Target{
-			&target.Target{Labels: map[string]string{
-				"__meta_certspotter_id":          "648494876",
-				"__meta_certspotter_cert_sha256": "",
-				"__meta_certspotter_cert_type":   "cert",
-			}},
-		},
+		dom     *domain
+		written int
+		want    int
+	}{"synced domain": {
+		&domain{synced: true},
+		2, 1,
+	}, "failed domain": {
+		&domain{synced: true, failed: true},
+		2, 2,
+	}, "unsynced domain": {
+		&domain{},
+		0, 1,
 	}}
 
 	for name, test := range table {
 		t.Logf("testing: %s", name)
 
-		got := GetTargets(test.issuances)
-		if !reflect.DeepEqual(got, test.want) {
-			t.Errorf("got: %+v want: %+v", got[0], test.want[0])
+		data, err := json.Marshal(make([]*target.Target, test.written))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cfgs := []*config.FileConfig{
+			&config.FileConfig{File: filename, Mode: 0644, UID: -1, GID: -1},
+		}
+		test.dom.cfg = &config.DomainConfig{Domain: "example.com"}
+		d := &Discovery{
+			cfg:     &config.Config{FileConfigs: cfgs},
+			domains: []*domain{test.dom},
+			index:   index.NewIndex(cfgs, &index.Options{}),
+			logger:  zap.NewNop().Sugar(),
+			written: map[string]*written{filename: &written{targets: test.written}},
+			dropped: make(map[string]int),
+		}
+		d.index.Add(test.dom.cfg, []*certspotter.Issuance{valid})
+		d.write()
+
+		data, err = ioutil.ReadFile(filename)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if got := Count(data); got != test.want {
+			t.Errorf("got: %d want: %d", got, test.want)
+		}
+	}
+}
+
+func TestDiscoveryReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certspotter-sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "targets.json")
+	cfg, err := config.Load(`
+domains:
+  - domain: example.com
+  - domain: example.org
+files:
+  - file: ` + filename + `
+    labels: {probe: tcp}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDiscovery(zap.NewNop(), cfg)
+	kept, removed := d.domains[0], d.domains[1]
+	kept.synced, removed.synced = true, true
+	d.index.Add(kept.cfg, []*certspotter.Issuance{&certspotter.Issuance{
+		ID:        "648494876",
+		DNSNames:  []string{"example.com"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}})
+	d.index.Add(removed.cfg, []*certspotter.Issuance{&certspotter.Issuance{
+		ID:        "648494877",
+		DNSNames:  []string{"example.org"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}})
+	d.write()
+
+	cfg, err = config.Load(`
+domains:
+  - domain: example.com
+files:
+  - file: ` + filename + `
+    labels: {probe: tls}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Reload(cfg)
+
+	if len(d.domains) != 1 || d.domains[0] != kept || d.domains[0].cfg != cfg.DomainConfigs[0] {
+		t.Errorf("got: %+v want: kept domain with reloaded configuration", d.domains)
+	}
+	select {
+	case <-d.reloaded:
+	default:
+		t.Errorf("got: no reload signal want: reload signal")
+	}
+
+	d.write()
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tgs []*target.Target
+	if err := json.Unmarshal(data, &tgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(tgs) != 1 || tgs[0].Labels["__meta_certspotter_labels_probe"] != "tls" {
+		t.Errorf("got: %+v want: single target with reloaded labels", tgs)
+	}
+
+	var canceled bool
+	kept.cancel = func() { canceled = true }
+	cfg, err = config.Load(`
+domains:
+  - domain: example.com
+    polling_interval: 5m
+    priority: 10
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Reload(cfg)
+
+	if !canceled || len(d.domains) != 1 || d.domains[0] != kept {
+		t.Errorf("got: canceled %t %+v want: restarted subscription of kept domain", canceled, d.domains)
+	}
+}
+
+func TestDiscoveryResume(t *testing.T) {
+	after := make(chan string, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/issuances", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case after <- r.URL.Query().Get("after"):
+		default:
+		}
+		w.Write([]byte(`[]`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	defer func(url string) { certspotter.BaseURL = url }(certspotter.BaseURL)
+	certspotter.BaseURL = ts.URL
+
+	cfg, err := config.Load(`
+domains:
+  - domain: example.com
+    include_subdomains: true
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDiscovery(zap.NewNop(), cfg)
+	apex := d.domains[0]
+	apex.synced, apex.after = true, "2"
+	d.index.Add(apex.cfg, []*certspotter.Issuance{&certspotter.Issuance{
+		ID:        "1",
+		DNSNames:  []string{"shop.example.com"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}, &certspotter.Issuance{
+		ID:        "2",
+		DNSNames:  []string{"www.example.com"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.ctx = ctx
+
+	// the query of example.com restarts covering shop.example.com
+	dir, err := ioutil.TempDir("", "certspotter-sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "targets.json")
+	cfg, err = config.Load(`
+domains:
+  - domain: example.com
+    include_subdomains: true
+  - domain: shop.example.com
+files:
+  - file: ` + filename + `
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Reload(cfg)
+
+	select {
+	case got := <-after:
+		if got != "2" {
+			t.Errorf("got: after %q want: after %q", got, "2")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("got: no request want: restarted query")
+	}
+	cancel()
+
+	d.mtx.Lock()
+	d.index.Update(time.Now())
+	data, _ := d.index.Render(filename)
+	d.mtx.Unlock()
+	var tgs []*target.Target
+	if err := json.Unmarshal(data, &tgs); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, tg := range tgs {
+		got[tg.Labels["__meta_certspotter_id"]] = tg.Labels["__meta_certspotter_domain"]
+	}
+	if got["1"] != "example.com;shop.example.com" || got["2"] != "example.com" {
+		t.Errorf("got: %v want: issuance 1 backfilled for shop.example.com", got)
+	}
+}
+
+func TestDiscoveryCollect(t *testing.T) {
+	cfg, err := config.Load(`
+domains:
+  - domain: collect.example.com
+    include_subdomains: true
+  - domain: shop.collect.example.com
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDiscovery(zap.NewNop(), cfg)
+	queries := plan(d.domains, time.Hour)
+	if len(queries) != 1 {
+		t.Fatalf("got: %d queries want: 1", len(queries))
+	}
+
+	// counters are global, compare the increase
+	want := map[string]float64{"collect.example.com": 2, "shop.collect.example.com": 1}
+	before := make(map[string]float64)
+	for domain := range want {
+		before[domain] = testutil.ToFloat64(issuancesDiscoveredMetric.WithLabelValues(domain))
+	}
+
+	ch := make(chan *client.Batch, 1)
+	ch <- &client.Batch{Issuances: []*certspotter.Issuance{
+		&certspotter.Issuance{ID: "1", DNSNames: []string{"shop.collect.example.com"}},
+		&certspotter.Issuance{ID: "2", DNSNames: []string{"www.collect.example.com"}},
+	}}
+	close(ch)
+	d.collect(context.Background(), queries[0], ch)
+
+	// routed issuances are counted for covered domains
+	for domain, n := range want {
+		got := testutil.ToFloat64(issuancesDiscoveredMetric.WithLabelValues(domain)) - before[domain]
+		if got != n {
+			t.Errorf("got: %v want: %v issuances discovered for %s", got, n, domain)
+		}
+	}
+}
+
+func TestDiscoveryExportFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certspotter-sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	table := map[string]struct {
+		filename string
+		ok       bool
+	}{"flushed": {
+		filepath.Join(dir, "targets.json"),
+		true,
+	}, "failed": {
+		filepath.Join(dir, "missing", "targets.json"),
+		false,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		cfg := &config.Config{
+			GlobalConfig: config.DefaultGlobalConfig,
+			FileConfigs: []*config.FileConfig{
+				&config.FileConfig{File: test.filename, Mode: 0644, UID: -1, GID: -1},
+			},
+		}
+		cfg.GlobalConfig.InitialSyncTimeout = time.Millisecond
+		d := NewDiscovery(zap.NewNop(), cfg)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		err := d.export(ctx)
+		cancel()
+		if (err == nil) != test.ok {
+			t.Errorf("got: %v want ok: %t", err, test.ok)
+		}
+		if _, err := os.Stat(test.filename); (err == nil) != test.ok {
+			t.Errorf("got: %v want file: %t", err, test.ok)
 		}
 	}
 }
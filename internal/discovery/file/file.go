@@ -0,0 +1,74 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Options are used when writing a file.
+type Options struct {
+	// Mode of written file.
+	Mode os.FileMode
+	// UID of written file or -1 to keep the current user.
+	UID int
+	// GID of written file or -1 to keep the current group.
+	GID int
+	// If missing parent directories should be created.
+	CreateDirs bool
+}
+
+// Write atomically writes data to filename. Data is written to a temporary
+// file in the same directory, which is synced and renamed into place.
+func Write(filename string, data []byte, opts *Options) error {
+	dir := filepath.Dir(filename)
+	if opts.CreateDirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp, data, opts); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// write writes data to file, sets ownership and permissions and syncs it.
+func write(file *os.File, data []byte, opts *Options) error {
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	if err := file.Chmod(opts.Mode); err != nil {
+		return err
+	}
+	if opts.UID != -1 || opts.GID != -1 {
+		if err := file.Chown(opts.UID, opts.GID); err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
+
+// syncDir syncs directory to persist a rename.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
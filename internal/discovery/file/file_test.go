@@ -0,0 +1,79 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	table := map[string]struct {
+		filename string
+		opts     *Options
+		ok       bool
+	}{"existing directory": {
+		"targets.json",
+		&Options{Mode: 0644, UID: -1, GID: -1},
+		true,
+	}, "restricted mode": {
+		"targets.json",
+		&Options{Mode: 0600, UID: -1, GID: -1},
+		true,
+	}, "current owner": {
+		"targets.json",
+		&Options{Mode: 0644, UID: os.Getuid(), GID: os.Getgid()},
+		true,
+	}, "missing directory": {
+		"missing/targets.json",
+		&Options{Mode: 0644, UID: -1, GID: -1},
+		false,
+	}, "created directory": {
+		"missing/targets.json",
+		&Options{Mode: 0644, UID: -1, GID: -1, CreateDirs: true},
+		true,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		dir, err := ioutil.TempDir("", "certspotter-sd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		filename := filepath.Join(dir, test.filename)
+		data := []byte("[]\n")
+
+		err = Write(filename, data, test.opts)
+		if (err == nil) != test.ok {
+			t.Errorf("got: %v want ok: %t", err, test.ok)
+		}
+		if !test.ok {
+			continue
+		}
+
+		got, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(got, data) {
+			t.Errorf("got: %s want: %s", got, data)
+		}
+
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if info.Mode() != test.opts.Mode {
+			t.Errorf("got: %s want: %s", info.Mode(), test.opts.Mode)
+		}
+
+		files, _ := ioutil.ReadDir(filepath.Dir(filename))
+		if len(files) != 1 {
+			t.Errorf("got: %d files want: 1", len(files))
+		}
+	}
+}
@@ -0,0 +1,743 @@
+package index
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecentric/certspotter-sd/internal/certspotter"
+	"github.com/codecentric/certspotter-sd/internal/config"
+	"github.com/codecentric/certspotter-sd/internal/discovery/record"
+	"github.com/codecentric/certspotter-sd/internal/discovery/target"
+	"github.com/codecentric/certspotter-sd/internal/zone"
+)
+
+// Index keeps targets of issuances and their file membership between
+// exports. Adding an issuance or expiring one only recomputes the targets
+// and files it affects.
+type Index struct {
+	entries    map[string]*entry
+	files      []*file
+	pending    entries
+	expiring   entries
+	aging      entries
+	now        time.Time
+	stale      map[*config.DomainConfig]bool
+	staleLabel bool
+	interner   *record.Interner
+	size       int
+	// hosts counts host names of issuances by parent name.
+	hosts map[string]map[string]int
+	// wildcards holds entries with wildcard names by parent name.
+	wildcards map[string]map[*entry]bool
+	uncovered int
+	zones     *zone.Inventory
+	// missing counts valid entries with names missing by zone origin.
+	missing map[string]int
+}
+
+// Options are used for configuring the index.
+type Options struct {
+	// If targets should be labeled with __meta_certspotter_stale.
+	StaleLabel bool
+	// Zones to label targets with __meta_certspotter_in_zone by if set.
+	Zones *zone.Inventory
+}
+
+// entry holds an issuance record with its domains.
+type entry struct {
+	record  *record.Record
+	domains []*config.DomainConfig
+	active  bool
+	// uncovered is set if no wildcard name of entry covers a host.
+	uncovered bool
+	// missing are the origins of zones missing names of entry.
+	missing []string
+	// next is the time the remaining days of entry change.
+	next time.Time
+	// aging is the index of entry in the aging heap or -1.
+	aging int
+}
+
+// member is the targets of an entry within a file.
+type member struct {
+	entry *entry
+	key   string
+	data  []byte
+	count int
+}
+
+// file holds the members of a file configuration.
+type file struct {
+	cfg     *config.FileConfig
+	members map[*entry]*member
+	sorted  []*member
+	added   []*member
+	removed int
+	size    int
+	// dropped holds the errors of targets of entries dropped while
+	// expanding.
+	dropped map[*entry][]error
+}
+
+// NewIndex returns a new index for file configurations.
+func NewIndex(cfgs []*config.FileConfig, opts *Options) *Index {
+	return &Index{
+		entries:    make(map[string]*entry),
+		files:      newFiles(cfgs),
+		pending:    entries{by: notBefore},
+		expiring:   entries{by: notAfter},
+		aging:      entries{by: next, index: agingIndex},
+		stale:      make(map[*config.DomainConfig]bool),
+		staleLabel: opts.StaleLabel,
+		interner:   record.NewInterner(),
+		hosts:      make(map[string]map[string]int),
+		wildcards:  make(map[string]map[*entry]bool),
+		zones:      opts.Zones,
+		missing:    make(map[string]int),
+	}
+}
+
+// Add adds issuances discovered for domain to the index. Issuances become
+// targets once they are valid during Update. Wildcard names of valid
+// issuances are expanded to the new host names.
+func (i *Index) Add(dom *config.DomainConfig, issuances []*certspotter.Issuance) {
+	changed := make(map[string]bool)
+	defer i.expand(changed)
+
+	for _, issuance := range issuances {
+		e, ok := i.entries[issuance.ID]
+		if !ok {
+			e = &entry{record: record.New(issuance, i.interner), aging: -1}
+			i.entries[issuance.ID] = e
+			i.size += e.record.Size()
+			i.register(e, changed)
+			heap.Push(&i.pending, e)
+		}
+		if e.hasDomain(dom) {
+			continue
+		}
+		e.domains = append(e.domains, dom)
+		if e.active {
+			i.compute(e)
+		}
+	}
+}
+
+// Backfill adds domain to the issuances of domain from whose dns names match,
+// e.g. for domains whose issuances are collected by the query of from.
+func (i *Index) Backfill(from, dom *config.DomainConfig, match func(names []string) bool) {
+	for _, e := range i.entries {
+		if !e.hasDomain(from) || e.hasDomain(dom) || !match(e.record.DNSNames) {
+			continue
+		}
+		e.domains = append(e.domains, dom)
+		if e.active {
+			i.compute(e)
+		}
+	}
+}
+
+// SetStale sets if issuances of domain are stale.
+func (i *Index) SetStale(dom *config.DomainConfig, stale bool) {
+	if i.stale[dom] == stale {
+		return
+	}
+	i.stale[dom] = stale
+
+	if !i.staleLabel {
+		return
+	}
+	for _, e := range i.entries {
+		if e.active && e.hasDomain(dom) {
+			i.compute(e)
+		}
+	}
+}
+
+// Reload replaces file configurations and options of index and maps domains
+// to their new configurations. Issuances of domains mapped to nil are dropped
+// once they belong to no domain. Valid issuances are recomputed.
+func (i *Index) Reload(cfgs []*config.FileConfig, domains map[*config.DomainConfig]*config.DomainConfig, opts *Options) {
+	i.files = newFiles(cfgs)
+	i.staleLabel = opts.StaleLabel
+	i.zones = opts.Zones
+
+	remap := func(dom *config.DomainConfig) *config.DomainConfig {
+		if cfg, ok := domains[dom]; ok {
+			return cfg
+		}
+		return dom
+	}
+
+	stale := make(map[*config.DomainConfig]bool, len(i.stale))
+	for dom, st := range i.stale {
+		if cfg := remap(dom); cfg != nil {
+			stale[cfg] = st
+		}
+	}
+	i.stale = stale
+
+	changed := make(map[string]bool)
+	deleted := make(map[*entry]bool)
+	for _, e := range i.entries {
+		doms := e.domains
+		e.domains = nil
+		for _, dom := range doms {
+			if cfg := remap(dom); cfg != nil && !e.hasDomain(cfg) {
+				e.domains = append(e.domains, cfg)
+			}
+		}
+		if len(e.domains) == 0 {
+			i.setUncovered(e, false)
+			i.setMissing(e, nil)
+			i.delete(e, changed)
+			deleted[e] = true
+		}
+	}
+	if len(deleted) != 0 {
+		keep := func(e *entry) bool { return !deleted[e] }
+		i.pending.filter(keep)
+		i.expiring.filter(keep)
+		i.aging.filter(keep)
+	}
+
+	for _, e := range i.entries {
+		if e.active {
+			i.compute(e)
+		}
+	}
+}
+
+// Update activates issuances which became valid, removes issuances which
+// expired and recomputes issuances whose remaining days changed at time now.
+func (i *Index) Update(now time.Time) {
+	i.now = now
+	changed := make(map[string]bool)
+	defer i.expand(changed)
+
+	for i.pending.Len() > 0 && !now.Before(i.pending.items[0].record.NotBefore) {
+		e := heap.Pop(&i.pending).(*entry)
+		if now.After(e.record.NotAfter) {
+			i.delete(e, changed)
+			continue
+		}
+		e.active = true
+		i.compute(e)
+		heap.Push(&i.expiring, e)
+		heap.Push(&i.aging, e)
+	}
+
+	for i.expiring.Len() > 0 && now.After(i.expiring.items[0].record.NotAfter) {
+		e := heap.Pop(&i.expiring).(*entry)
+		e.active = false
+		i.remove(e)
+		i.delete(e, changed)
+	}
+
+	for i.aging.Len() > 0 && now.After(i.aging.items[0].next) {
+		e := heap.Pop(&i.aging).(*entry)
+		if !e.active {
+			continue
+		}
+		i.compute(e)
+		heap.Push(&i.aging, e)
+	}
+}
+
+// Stats are statistics about the contents of an index.
+type Stats struct {
+	// Issuances stored in the index.
+	Issuances int
+	// Targets of valid issuances.
+	Targets int
+	// Bytes approximately used by issuance records.
+	Bytes int
+	// Strings interned by records.
+	Strings int
+	// StringBytes used by interned strings.
+	StringBytes int
+	// Uncovered valid issuances with wildcard names not covering any host.
+	Uncovered int
+	// Missing valid issuances with names missing from zones by origin.
+	Missing map[string]int
+}
+
+// Stats returns statistics about the index.
+func (i *Index) Stats() *Stats {
+	strs, bytes := i.interner.Len()
+	missing := make(map[string]int, len(i.missing))
+	for origin, count := range i.missing {
+		missing[origin] = count
+	}
+	return &Stats{
+		Issuances:   len(i.entries),
+		Targets:     i.expiring.Len(),
+		Bytes:       i.size,
+		Strings:     strs,
+		StringBytes: bytes,
+		Uncovered:   i.uncovered,
+		Missing:     missing,
+	}
+}
+
+// Render returns targets of filename rendered as sorted json array and the
+// number of targets rendered.
+func (i *Index) Render(filename string) ([]byte, int) {
+	var members []*member
+	var merged bool
+	var size, count int
+	for _, f := range i.files {
+		if f.cfg.File != filename {
+			continue
+		}
+		merged = members != nil
+		sorted := f.Sorted()
+		members = append(members, sorted...)
+		size += f.size + len(sorted)
+	}
+	if merged {
+		sort.SliceStable(members, func(i, j int) bool {
+			return members[i].key < members[j].key
+		})
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(size + 2)
+	buf.WriteByte('[')
+	for n, m := range members {
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(m.data)
+		count += m.count
+	}
+	buf.WriteString("]\n")
+	return buf.Bytes(), count
+}
+
+// Dropped returns the number of targets of filename dropped because their
+// templates failed to render or rendered empty addresses and one of their
+// errors as example.
+func (i *Index) Dropped(filename string) (int, error) {
+	var count int
+	var example error
+	for _, f := range i.files {
+		if f.cfg.File != filename {
+			continue
+		}
+		for _, errs := range f.dropped {
+			count += len(errs)
+			example = errs[0]
+		}
+	}
+	return count, example
+}
+
+// compute computes the targets and file membership of entry.
+func (i *Index) compute(e *entry) {
+	days := target.DaysRemaining(e.record, i.now)
+	if next := e.record.NotAfter.Add(-time.Duration(days) * time.Hour * 24); !next.Equal(e.next) {
+		e.next = next
+		if e.aging >= 0 {
+			heap.Fix(&i.aging, e.aging)
+		}
+	}
+
+	tg := target.NewTarget(e.record)
+	tg.AddDomains(e.domains)
+	if i.staleLabel {
+		tg.Labels["__meta_certspotter_stale"] = strconv.FormatBool(i.isStale(e))
+	}
+	if i.zones != nil {
+		all, missing := i.zones.Missing(e.record.DNSNames)
+		tg.Labels["__meta_certspotter_in_zone"] = strconv.FormatBool(all)
+		i.setMissing(e, missing)
+	} else {
+		i.setMissing(e, nil)
+	}
+	base := i.wildcardTargets(e, tg)
+
+	for _, f := range i.files {
+		var tgs []*target.Target
+		var dropped []error
+		for _, tg := range base {
+			ftg := fileTarget(tg, f.cfg)
+			if f.cfg.DaysRemaining {
+				ftg.Labels["__meta_certspotter_days_remaining"] = strconv.Itoa(days)
+			}
+			if !ftg.Matches(f.cfg.MatchRE) || !f.cfg.Selectors.Matches(ftg.Labels) {
+				continue
+			}
+			etgs, errs := ftg.Expand(f.cfg, e.record)
+			for _, etg := range etgs {
+				tgs = append(tgs, etg.Relabel(f.cfg.RelabelConfigs)...)
+			}
+			dropped = append(dropped, errs...)
+		}
+		f.setDropped(e, dropped)
+		if len(tgs) == 0 {
+			f.remove(e)
+			continue
+		}
+		f.add(e, newMember(e, tgs))
+	}
+}
+
+// wildcardTargets returns target and a target per wildcard name of entry
+// with the hosts it covers. Target is omitted if it has no addresses but
+// wildcard names cover hosts.
+func (i *Index) wildcardTargets(e *entry, tg *target.Target) []*target.Target {
+	tgs := []*target.Target{tg}
+	var wildcard bool
+	for _, name := range e.record.DNSNames {
+		if !strings.HasPrefix(name, "*.") {
+			continue
+		}
+		wildcard = true
+		if hosts := i.wildcardHosts(e, name); len(hosts) != 0 {
+			tgs = append(tgs, tg.Wildcard(name, hosts))
+		}
+	}
+
+	i.setUncovered(e, wildcard && len(tgs) == 1)
+	if len(tgs) > 1 && len(tg.Targets) == 0 {
+		tgs = tgs[1:]
+	}
+	return tgs
+}
+
+// wildcardHosts returns the sorted hosts covered by wildcard name of entry.
+// These are host names of other issuances and wildcard hosts of the
+// domains of entry.
+func (i *Index) wildcardHosts(e *entry, name string) []string {
+	parent := target.Parent(name)
+	own := make(map[string]bool, len(e.record.DNSNames))
+	for _, name := range e.record.DNSNames {
+		own[name] = true
+	}
+
+	set := make(map[string]bool)
+	for host := range i.hosts[parent] {
+		set[host] = true
+	}
+	for _, dom := range e.domains {
+		for _, host := range dom.WildcardHosts {
+			if target.Parent(host) == parent {
+				set[host] = true
+			}
+		}
+	}
+
+	var hosts []string
+	for host := range set {
+		if !own[host] {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// setUncovered sets if wildcard names of entry cover no host.
+func (i *Index) setUncovered(e *entry, uncovered bool) {
+	if e.uncovered == uncovered {
+		return
+	}
+	e.uncovered = uncovered
+	if uncovered {
+		i.uncovered++
+	} else {
+		i.uncovered--
+	}
+}
+
+// setMissing sets the origins of zones missing names of entry.
+func (i *Index) setMissing(e *entry, missing []string) {
+	for _, origin := range e.missing {
+		if i.missing[origin]--; i.missing[origin] == 0 {
+			delete(i.missing, origin)
+		}
+	}
+	e.missing = missing
+	for _, origin := range missing {
+		i.missing[origin]++
+	}
+}
+
+// register counts the names of entry and adds parents with new host names
+// to changed.
+func (i *Index) register(e *entry, changed map[string]bool) {
+	for _, name := range e.record.DNSNames {
+		parent := target.Parent(name)
+		if strings.HasPrefix(name, "*.") {
+			if i.wildcards[parent] == nil {
+				i.wildcards[parent] = make(map[*entry]bool)
+			}
+			i.wildcards[parent][e] = true
+			continue
+		}
+		if i.hosts[parent] == nil {
+			i.hosts[parent] = make(map[string]int)
+		}
+		if i.hosts[parent][name] == 0 {
+			changed[parent] = true
+		}
+		i.hosts[parent][name]++
+	}
+}
+
+// unregister reverts register.
+func (i *Index) unregister(e *entry, changed map[string]bool) {
+	for _, name := range e.record.DNSNames {
+		parent := target.Parent(name)
+		if strings.HasPrefix(name, "*.") {
+			delete(i.wildcards[parent], e)
+			if len(i.wildcards[parent]) == 0 {
+				delete(i.wildcards, parent)
+			}
+			continue
+		}
+		if i.hosts[parent][name]--; i.hosts[parent][name] > 0 {
+			continue
+		}
+		changed[parent] = true
+		delete(i.hosts[parent], name)
+		if len(i.hosts[parent]) == 0 {
+			delete(i.hosts, parent)
+		}
+	}
+}
+
+// expand recomputes valid entries with wildcard names below changed parents.
+func (i *Index) expand(changed map[string]bool) {
+	for parent := range changed {
+		for e := range i.wildcards[parent] {
+			if e.active {
+				i.compute(e)
+			}
+		}
+	}
+}
+
+// delete deletes entry from index.
+func (i *Index) delete(e *entry, changed map[string]bool) {
+	delete(i.entries, e.record.ID)
+	i.size -= e.record.Size()
+	i.unregister(e, changed)
+}
+
+// remove removes entry from all files.
+func (i *Index) remove(e *entry) {
+	for _, f := range i.files {
+		f.remove(e)
+		f.setDropped(e, nil)
+	}
+	i.setUncovered(e, false)
+	i.setMissing(e, nil)
+}
+
+// isStale returns if all domains of entry are stale.
+func (i *Index) isStale(e *entry) bool {
+	for _, dom := range e.domains {
+		if !i.stale[dom] {
+			return false
+		}
+	}
+	return true
+}
+
+// hasDomain returns if entry was discovered for domain.
+func (e *entry) hasDomain(dom *config.DomainConfig) bool {
+	for _, d := range e.domains {
+		if d == dom {
+			return true
+		}
+	}
+	return false
+}
+
+// newFiles returns empty files of file configurations.
+func newFiles(cfgs []*config.FileConfig) []*file {
+	files := make([]*file, len(cfgs))
+	for i, cfg := range cfgs {
+		files[i] = &file{
+			cfg:     cfg,
+			members: make(map[*entry]*member),
+			dropped: make(map[*entry][]error),
+		}
+	}
+	return files
+}
+
+// fileTarget returns a copy of target with file labels and ip addresses
+// removed unless included by file.
+func fileTarget(tg *target.Target, cfg *config.FileConfig) *target.Target {
+	labels := make(map[string]string, len(tg.Labels)+len(cfg.Labels))
+	for name, val := range tg.Labels {
+		labels[name] = val
+	}
+	var addrs []string
+	for _, addr := range tg.Targets {
+		if cfg.IncludeIPSANs || net.ParseIP(addr) == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+
+	cp := &target.Target{Labels: labels, Targets: addrs}
+	cp.AddLabels(cfg.Labels)
+	return cp
+}
+
+// newMember returns the member of entry rendering targets.
+func newMember(e *entry, tgs []*target.Target) *member {
+	var data []byte
+	for n, tg := range tgs {
+		if n > 0 {
+			data = append(data, ',')
+		}
+		d, _ := json.Marshal(tg)
+		data = append(data, d...)
+	}
+	return &member{entry: e, key: tgs[0].Key(), data: data, count: len(tgs)}
+}
+
+// add adds or replaces the member of entry.
+func (f *file) add(e *entry, m *member) {
+	f.remove(e)
+	f.members[e] = m
+	f.added = append(f.added, m)
+	f.size += len(m.data)
+}
+
+// remove removes the member of entry.
+func (f *file) remove(e *entry) {
+	m, ok := f.members[e]
+	if !ok {
+		return
+	}
+	delete(f.members, e)
+	f.removed++
+	f.size -= len(m.data)
+}
+
+// setDropped sets the errors of targets of entry dropped while expanding.
+func (f *file) setDropped(e *entry, errs []error) {
+	if len(errs) == 0 {
+		delete(f.dropped, e)
+		return
+	}
+	f.dropped[e] = errs
+}
+
+// Sorted returns members of file sorted by key. Added members are sorted
+// and merged, removed members are dropped.
+func (f *file) Sorted() []*member {
+	if len(f.added) == 0 && f.removed == 0 {
+		return f.sorted
+	}
+
+	added := f.added[:0]
+	for _, m := range f.added {
+		if f.members[m.entry] == m {
+			added = append(added, m)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool {
+		return added[i].less(added[j])
+	})
+
+	sorted := make([]*member, 0, len(f.members))
+	var n int
+	for _, m := range f.sorted {
+		if f.members[m.entry] != m {
+			continue
+		}
+		for ; n < len(added) && added[n].less(m); n++ {
+			sorted = append(sorted, added[n])
+		}
+		sorted = append(sorted, m)
+	}
+	sorted = append(sorted, added[n:]...)
+
+	f.sorted, f.added, f.removed = sorted, nil, 0
+	return f.sorted
+}
+
+// less orders members by key and, as relabeling may drop the issuance id
+// from keys, by issuance id.
+func (m *member) less(o *member) bool {
+	if m.key != o.key {
+		return m.key < o.key
+	}
+	return m.entry.record.ID < o.entry.record.ID
+}
+
+// entries implements heap.Interface ordered by time. Positions of entries
+// are tracked if index is set.
+type entries struct {
+	items []*entry
+	by    func(*entry) time.Time
+	index func(*entry) *int
+}
+
+func notBefore(e *entry) time.Time { return e.record.NotBefore }
+func notAfter(e *entry) time.Time  { return e.record.NotAfter }
+func next(e *entry) time.Time      { return e.next }
+func agingIndex(e *entry) *int     { return &e.aging }
+
+// Len, Less, Swap, Push, Pop implement heap.Interface
+func (es *entries) Len() int           { return len(es.items) }
+func (es *entries) Less(i, j int) bool { return es.by(es.items[i]).Before(es.by(es.items[j])) }
+func (es *entries) Swap(i, j int) {
+	es.items[i], es.items[j] = es.items[j], es.items[i]
+	es.setIndex(i)
+	es.setIndex(j)
+}
+func (es *entries) Push(x interface{}) {
+	es.items = append(es.items, x.(*entry))
+	es.setIndex(len(es.items) - 1)
+}
+func (es *entries) Pop() interface{} {
+	n := len(es.items) - 1
+	e := es.items[n]
+	es.items[n] = nil
+	es.items = es.items[:n]
+	if es.index != nil {
+		*es.index(e) = -1
+	}
+	return e
+}
+
+// setIndex tracks the position n of its entry if index is set.
+func (es *entries) setIndex(n int) {
+	if es.index != nil {
+		*es.index(es.items[n]) = n
+	}
+}
+
+// filter removes entries not kept from heap.
+func (es *entries) filter(keep func(*entry) bool) {
+	items := es.items[:0]
+	for _, e := range es.items {
+		if keep(e) {
+			items = append(items, e)
+		} else if es.index != nil {
+			*es.index(e) = -1
+		}
+	}
+	for n := len(items); n < len(es.items); n++ {
+		es.items[n] = nil
+	}
+	es.items = items
+	for n := range es.items {
+		es.setIndex(n)
+	}
+	heap.Init(es)
+}
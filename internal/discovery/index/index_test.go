@@ -0,0 +1,856 @@
+package index
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecentric/certspotter-sd/internal/certspotter"
+	"github.com/codecentric/certspotter-sd/internal/config"
+	"github.com/codecentric/certspotter-sd/internal/discovery/target"
+	"github.com/codecentric/certspotter-sd/internal/zone"
+)
+
+func mustParseTime(str string) time.Time {
+	time, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		panic(err)
+	}
+	return time
+}
+
+func mustRender(idx *Index, filename string) []*target.Target {
+	data, n := idx.Render(filename)
+
+	var tgs []*target.Target
+	if err := json.Unmarshal(data, &tgs); err != nil {
+		panic(err)
+	}
+	if len(tgs) != n {
+		panic(fmt.Sprintf("rendered %d targets but counted %d", len(tgs), n))
+	}
+	return tgs
+}
+
+var now = mustParseTime("2020-01-01T00:00:00-00:00")
+
+// valid adds validity labels of issuances valid from 2000 until 2100 to labels.
+func valid(labels map[string]string) map[string]string {
+	labels["__meta_certspotter_not_before"] = "2000-01-01T00:00:00Z"
+	labels["__meta_certspotter_not_before_timestamp"] = "946684800"
+	labels["__meta_certspotter_not_after"] = "2100-01-01T00:00:00Z"
+	labels["__meta_certspotter_not_after_timestamp"] = "4102444800"
+	labels["__meta_certspotter_lifetime_days"] = "36525"
+	return labels
+}
+
+func TestIndexUpdate(t *testing.T) {
+	table := map[string]struct {
+		issuances []*certspotter.Issuance
+		want      []*target.Target
+	}{"valid issuances": {
+		[]*certspotter.Issuance{
+			&certspotter.Issuance{
+				ID:          "648494876",
+				NotBefore:   mustParseTime("2000-01-01T00:00:00-00:00"),
+				NotAfter:    mustParseTime("2100-01-01T00:00:00-00:00"),
+				Certificate: &certspotter.Certificate{Type: "cert"},
+			},
+			&certspotter.Issuance{
+				ID:          "648494877",
+				NotBefore:   mustParseTime("2000-01-01T00:00:00-00:00"),
+				NotAfter:    mustParseTime("2100-01-01T00:00:00-00:00"),
+				Certificate: &certspotter.Certificate{Type: "precert"},
+			},
+		},
+		[]*target.Target{
+			&target.Target{Labels: valid(map[string]string{
+				"__meta_certspotter_id":          "648494876",
+				"__meta_certspotter_domain":      "example.com",
+				"__meta_certspotter_cert_sha256": "",
+				"__meta_certspotter_cert_type":   "cert",
+			})},
+			&target.Target{Labels: valid(map[string]string{
+				"__meta_certspotter_id":          "648494877",
+				"__meta_certspotter_domain":      "example.com",
+				"__meta_certspotter_cert_sha256": "",
+				"__meta_certspotter_cert_type":   "precert",
+			})},
+		},
+	}, "duplicate issuances": {
+		[]*certspotter.Issuance{
+			&certspotter.Issuance{
+				ID:          "648494876",
+				NotBefore:   mustParseTime("2000-01-01T00:00:00-00:00"),
+				NotAfter:    mustParseTime("2100-01-01T00:00:00-00:00"),
+				Certificate: &certspotter.Certificate{Type: "cert"},
+			},
+			&certspotter.Issuance{
+				ID:          "648494876",
+				NotBefore:   mustParseTime("2000-01-01T00:00:00-00:00"),
+				NotAfter:    mustParseTime("2100-01-01T00:00:00-00:00"),
+				Certificate: &certspotter.Certificate{Type: "cert"},
+			},
+		},
+		[]*target.Target{
+			&target.Target{Labels: valid(map[string]string{
+				"__meta_certspotter_id":          "648494876",
+				"__meta_certspotter_domain":      "example.com",
+				"__meta_certspotter_cert_sha256": "",
+				"__meta_certspotter_cert_type":   "cert",
+			})},
+		},
+	}, "outdated issuances": {
+		[]*certspotter.Issuance{
+			&certspotter.Issuance{
+				ID:          "648494876",
+				NotBefore:   mustParseTime("2000-01-01T00:00:00-00:00"),
+				NotAfter:    mustParseTime("2100-01-01T00:00:00-00:00"),
+				Certificate: &certspotter.Certificate{Type: "cert"},
+			},
+			&certspotter.Issuance{
+				ID:          "648494877",
+				NotBefore:   mustParseTime("2000-01-01T00:00:00-00:00"),
+				NotAfter:    mustParseTime("2000-01-01T00:00:00-00:00"),
+				Certificate: &certspotter.Certificate{Type: "cert"},
+			},
+			&certspotter.Issuance{
+				ID:          "648494878",
+				NotBefore:   mustParseTime("2100-01-01T00:00:00-00:00"),
+				NotAfter:    mustParseTime("2100-01-01T00:00:00-00:00"),
+				Certificate: &certspotter.Certificate{Type: "cert"},
+			},
+		},
+		[]*target.Target{
+			&target.Target{Labels: valid(map[string]string{
+				"__meta_certspotter_id":          "648494876",
+				"__meta_certspotter_domain":      "example.com",
+				"__meta_certspotter_cert_sha256": "",
+				"__meta_certspotter_cert_type":   "cert",
+			})},
+		},
+	}}
+
+	dom := &config.DomainConfig{Domain: "example.com"}
+	cfgs := []*config.FileConfig{&config.FileConfig{File: "targets.json"}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		idx := NewIndex(cfgs, &Options{})
+		idx.Add(dom, test.issuances)
+		idx.Update(now)
+
+		got := mustRender(idx, "targets.json")
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got: %+v want: %+v", got, test.want)
+		}
+	}
+}
+
+func TestIndexExpiry(t *testing.T) {
+	dom := &config.DomainConfig{Domain: "example.com"}
+	cfgs := []*config.FileConfig{&config.FileConfig{File: "targets.json"}}
+
+	idx := NewIndex(cfgs, &Options{})
+	idx.Add(dom, []*certspotter.Issuance{&certspotter.Issuance{
+		ID:        "648494876",
+		NotBefore: mustParseTime("2020-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2020-02-01T00:00:00-00:00"),
+	}})
+
+	table := []struct {
+		now  time.Time
+		want int
+	}{
+		{mustParseTime("2019-12-31T00:00:00-00:00"), 0},
+		{mustParseTime("2020-01-01T00:00:00-00:00"), 1},
+		{mustParseTime("2020-02-01T00:00:00-00:00"), 1},
+		{mustParseTime("2020-02-02T00:00:00-00:00"), 0},
+	}
+
+	for _, test := range table {
+		t.Logf("testing: %s", test.now)
+
+		idx.Update(test.now)
+		if _, got := idx.Render("targets.json"); got != test.want {
+			t.Errorf("got: %d want: %d", got, test.want)
+		}
+	}
+	if stats := idx.Stats(); stats.Issuances != 0 || stats.Bytes != 0 {
+		t.Errorf("got: %+v want: no issuances", stats)
+	}
+}
+
+func TestIndexFiles(t *testing.T) {
+	dom := &config.DomainConfig{Domain: "example.com"}
+	cfgs := []*config.FileConfig{
+		&config.FileConfig{
+			File:    "a.json",
+			Labels:  map[string]string{"file": "a"},
+			MatchRE: config.MatchRE{"dns_names": regexp.MustCompile("^a.example.com$")},
+		},
+		&config.FileConfig{
+			File:   "b.json",
+			Labels: map[string]string{"file": "b"},
+		},
+	}
+	issuances := []*certspotter.Issuance{
+		&certspotter.Issuance{
+			ID:        "648494876",
+			DNSNames:  []string{"a.example.com"},
+			NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+			NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+		},
+		&certspotter.Issuance{
+			ID:        "648494877",
+			DNSNames:  []string{"b.example.com"},
+			NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+			NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+		},
+	}
+
+	idx := NewIndex(cfgs, &Options{})
+	idx.Add(dom, issuances)
+	idx.Update(now)
+
+	a := mustRender(idx, "a.json")
+	want := []*target.Target{&target.Target{
+		Labels: valid(map[string]string{
+			"__meta_certspotter_id":                "648494876",
+			"__meta_certspotter_domain":            "example.com",
+			"__meta_certspotter_dns_names":         "a.example.com",
+			"__meta_certspotter_dns_names_unicode": "a.example.com",
+			"__meta_certspotter_labels_file":       "a",
+		}),
+		Targets: []string{"a.example.com"},
+	}}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("got: %+v want: %+v", a, want)
+	}
+
+	b := mustRender(idx, "b.json")
+	if len(b) != 2 {
+		t.Fatalf("got: %d targets want: 2", len(b))
+	}
+	for _, tg := range b {
+		if tg.Labels["__meta_certspotter_labels_file"] != "b" {
+			t.Errorf("got: %+v want file label b", tg.Labels)
+		}
+	}
+}
+
+func TestIndexSelectors(t *testing.T) {
+	mustParseSelector := func(str string) config.Selector {
+		sel, err := config.ParseSelector(str)
+		if err != nil {
+			panic(err)
+		}
+		return sel
+	}
+
+	dom := &config.DomainConfig{Domain: "example.com", Labels: map[string]string{"team": "web"}}
+	cfgs := []*config.FileConfig{&config.FileConfig{
+		File:   "targets.json",
+		Labels: map[string]string{"probe": "tls"},
+		Selectors: config.Selectors{
+			mustParseSelector(`{__meta_certspotter_issuer_name!~".*Encrypt.*", __meta_certspotter_labels_probe="tls"}`),
+			mustParseSelector(`{__meta_certspotter_dns_names="a.example.com"}`),
+		},
+	}}
+	issuances := []*certspotter.Issuance{
+		&certspotter.Issuance{
+			ID:        "648494876",
+			DNSNames:  []string{"a.example.com"},
+			Issuer:    &certspotter.Issuer{Name: "CN=Let's Encrypt R3"},
+			NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+			NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+		},
+		&certspotter.Issuance{
+			ID:        "648494877",
+			DNSNames:  []string{"b.example.com"},
+			Issuer:    &certspotter.Issuer{Name: "CN=Let's Encrypt R3"},
+			NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+			NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+		},
+		&certspotter.Issuance{
+			ID:        "648494878",
+			DNSNames:  []string{"c.example.com"},
+			Issuer:    &certspotter.Issuer{Name: "CN=DigiCert SHA2 Secure Server CA"},
+			NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+			NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+		},
+	}
+
+	idx := NewIndex(cfgs, &Options{})
+	idx.Add(dom, issuances)
+	idx.Update(now)
+
+	var got []string
+	for _, tg := range mustRender(idx, "targets.json") {
+		got = append(got, tg.Labels["__meta_certspotter_id"])
+	}
+	want := []string{"648494876", "648494878"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v want: %v", got, want)
+	}
+}
+
+func TestIndexRelabel(t *testing.T) {
+	dom := &config.DomainConfig{Domain: "example.com", Labels: map[string]string{"team": "web"}}
+	cfgs := []*config.FileConfig{&config.FileConfig{
+		File: "targets.json",
+		RelabelConfigs: []*config.RelabelConfig{&config.RelabelConfig{
+			SourceLabels: []string{"__address__"},
+			Regex:        config.MustNewRegexp(`b\..*`),
+			Action:       config.RelabelDrop,
+		}, &config.RelabelConfig{
+			Regex:       config.MustNewRegexp(`__meta_certspotter_labels_(.+)`),
+			Replacement: "$1",
+			Action:      config.RelabelLabelMap,
+		}, &config.RelabelConfig{
+			Regex:  config.MustNewRegexp(`__meta_.*`),
+			Action: config.RelabelLabelDrop,
+		}, &config.RelabelConfig{
+			SourceLabels: []string{"__address__"},
+			Separator:    ";",
+			Regex:        config.MustNewRegexp(`(.*)`),
+			TargetLabel:  "__address__",
+			Replacement:  "$1:443",
+			Action:       config.RelabelReplace,
+		}},
+	}}
+	issuances := []*certspotter.Issuance{
+		&certspotter.Issuance{
+			ID:        "648494876",
+			DNSNames:  []string{"c.example.com", "a.example.com", "b.example.com"},
+			NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+			NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+		},
+		&certspotter.Issuance{
+			ID:        "648494877",
+			DNSNames:  []string{"b.example.com"},
+			NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+			NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+		},
+	}
+
+	idx := NewIndex(cfgs, &Options{})
+	idx.Add(dom, issuances)
+	idx.Update(now)
+
+	got := mustRender(idx, "targets.json")
+	want := []*target.Target{
+		&target.Target{Labels: map[string]string{"team": "web"}, Targets: []string{"a.example.com:443"}},
+		&target.Target{Labels: map[string]string{"team": "web"}, Targets: []string{"c.example.com:443"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %+v want: %+v", got, want)
+	}
+}
+
+func TestIndexSortedEqualKeys(t *testing.T) {
+	dom := &config.DomainConfig{Domain: "example.com"}
+	cfgs := []*config.FileConfig{&config.FileConfig{
+		File: "targets.json",
+		RelabelConfigs: []*config.RelabelConfig{&config.RelabelConfig{
+			Regex:  config.MustNewRegexp(`__meta_certspotter_id`),
+			Action: config.RelabelLabelDrop,
+		}},
+	}}
+
+	var issuances []*certspotter.Issuance
+	for _, id := range []string{"5", "3", "9", "1", "7"} {
+		issuances = append(issuances, &certspotter.Issuance{
+			ID:        id,
+			DNSNames:  []string{"example.com"},
+			NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+			NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+		})
+	}
+
+	// members with equal keys are rendered in the order of their ids
+	for n := 0; n < 10; n++ {
+		idx := NewIndex(cfgs, &Options{})
+		for _, issuance := range issuances {
+			idx.Add(dom, []*certspotter.Issuance{issuance})
+		}
+		idx.Update(now)
+
+		var ids []string
+		for _, m := range idx.files[0].Sorted() {
+			ids = append(ids, m.entry.record.ID)
+		}
+		if got := strings.Join(ids, ","); got != "1,3,5,7,9" {
+			t.Errorf("got: %s want: 1,3,5,7,9", got)
+		}
+	}
+}
+
+func TestIndexWildcards(t *testing.T) {
+	dom := &config.DomainConfig{Domain: "example.com", WildcardHosts: []string{"static.example.com"}}
+	cfgs := []*config.FileConfig{&config.FileConfig{File: "targets.json"}}
+
+	idx := NewIndex(cfgs, &Options{})
+	idx.Add(dom, []*certspotter.Issuance{&certspotter.Issuance{
+		ID:        "648494876",
+		DNSNames:  []string{"*.example.com", "example.com"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}, &certspotter.Issuance{
+		ID:        "648494877",
+		DNSNames:  []string{"*.shop.example.com"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}})
+	idx.Update(now)
+
+	addrs := func() map[string][][]string {
+		addrs := make(map[string][][]string)
+		for _, tg := range mustRender(idx, "targets.json") {
+			id := tg.Labels["__meta_certspotter_id"] + tg.Labels["__meta_certspotter_wildcard"]
+			addrs[id] = append(addrs[id], tg.Targets)
+		}
+		return addrs
+	}
+
+	want := map[string][][]string{
+		"648494876":              [][]string{[]string{"example.com"}},
+		"648494876*.example.com": [][]string{[]string{"static.example.com"}},
+		"648494877":              [][]string{nil},
+	}
+	if got := addrs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v want: %v", got, want)
+	}
+	if got := idx.Stats().Uncovered; got != 1 {
+		t.Errorf("got: %d want: 1", got)
+	}
+
+	idx.Add(dom, []*certspotter.Issuance{&certspotter.Issuance{
+		ID:        "648494878",
+		DNSNames:  []string{"a.example.com", "cart.shop.example.com"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2020-02-01T00:00:00-00:00"),
+	}})
+	idx.Update(now)
+
+	want = map[string][][]string{
+		"648494876":                   [][]string{[]string{"example.com"}},
+		"648494876*.example.com":      [][]string{[]string{"a.example.com", "static.example.com"}},
+		"648494877*.shop.example.com": [][]string{[]string{"cart.shop.example.com"}},
+		"648494878":                   [][]string{[]string{"a.example.com", "cart.shop.example.com"}},
+	}
+	if got := addrs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v want: %v", got, want)
+	}
+	if got := idx.Stats().Uncovered; got != 0 {
+		t.Errorf("got: %d want: 0", got)
+	}
+
+	idx.Update(mustParseTime("2020-03-01T00:00:00-00:00"))
+
+	want = map[string][][]string{
+		"648494876":              [][]string{[]string{"example.com"}},
+		"648494876*.example.com": [][]string{[]string{"static.example.com"}},
+		"648494877":              [][]string{nil},
+	}
+	if got := addrs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v want: %v", got, want)
+	}
+	if got := idx.Stats().Uncovered; got != 1 {
+		t.Errorf("got: %d want: 1", got)
+	}
+}
+
+func TestIndexIPSANs(t *testing.T) {
+	dom := &config.DomainConfig{Domain: "example.com"}
+	cfgs := []*config.FileConfig{
+		&config.FileConfig{File: "all.json", IncludeIPSANs: true},
+		&config.FileConfig{File: "hosts.json"},
+	}
+
+	idx := NewIndex(cfgs, &Options{})
+	idx.Add(dom, []*certspotter.Issuance{&certspotter.Issuance{
+		ID:        "648494876",
+		DNSNames:  []string{"example.com", "192.0.2.1"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}})
+	idx.Update(now)
+
+	table := map[string][]string{
+		"all.json":   []string{"192.0.2.1", "example.com"},
+		"hosts.json": []string{"example.com"},
+	}
+
+	for filename, want := range table {
+		t.Logf("testing: %s", filename)
+
+		tgs := mustRender(idx, filename)
+		if got := tgs[0].Targets; !reflect.DeepEqual(got, want) {
+			t.Errorf("got: %v want: %v", got, want)
+		}
+	}
+}
+
+func TestIndexZones(t *testing.T) {
+	dom := &config.DomainConfig{Domain: "example.com", IncludeSubdomains: true}
+	cfgs := []*config.FileConfig{&config.FileConfig{File: "targets.json"}}
+	z, err := zone.Parse(strings.NewReader("$ORIGIN example.com.\n@ SOA ns1 hostmaster 1 2 3 4 5\nwww A 192.0.2.1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx := NewIndex(cfgs, &Options{Zones: zone.NewInventory([]*zone.Zone{z})})
+	idx.Add(dom, []*certspotter.Issuance{&certspotter.Issuance{
+		ID:        "648494876",
+		DNSNames:  []string{"www.example.com"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}, &certspotter.Issuance{
+		ID:        "648494877",
+		DNSNames:  []string{"www.example.com", "legacy.example.com"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}})
+	idx.Update(now)
+
+	table := map[string]string{
+		"www.example.com":    "true",
+		"legacy.example.com": "false",
+	}
+
+	tgs := mustRender(idx, "targets.json")
+	if len(tgs) != len(table) {
+		t.Fatalf("got: %d targets want: %d", len(tgs), len(table))
+	}
+	for _, tg := range tgs {
+		name := tg.Targets[0]
+		t.Logf("testing: %s", name)
+
+		if got, want := tg.Labels["__meta_certspotter_in_zone"], table[name]; got != want {
+			t.Errorf("got: %s want: %s", got, want)
+		}
+	}
+	if got, want := idx.Stats().Missing, map[string]int{"example.com": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v want: %v", got, want)
+	}
+
+	idx.Reload(cfgs, nil, &Options{})
+	if got := mustRender(idx, "targets.json")[0].Labels["__meta_certspotter_in_zone"]; got != "" {
+		t.Errorf("got: %s want: no label", got)
+	}
+	if got := idx.Stats().Missing; len(got) != 0 {
+		t.Errorf("got: %v want: no missing", got)
+	}
+}
+
+func TestIndexReload(t *testing.T) {
+	apex := &config.DomainConfig{Domain: "example.com", Labels: map[string]string{"team": "web"}}
+	shop := &config.DomainConfig{Domain: "shop.example.com"}
+	cfgs := []*config.FileConfig{&config.FileConfig{File: "targets.json"}}
+
+	idx := NewIndex(cfgs, &Options{})
+	idx.Add(apex, []*certspotter.Issuance{&certspotter.Issuance{
+		ID:        "648494876",
+		DNSNames:  []string{"example.com"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}})
+	idx.Add(shop, []*certspotter.Issuance{&certspotter.Issuance{
+		ID:        "648494877",
+		DNSNames:  []string{"shop.example.com"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}, &certspotter.Issuance{
+		ID:        "648494878",
+		NotBefore: mustParseTime("2050-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}})
+	idx.Update(now)
+
+	reloaded := &config.DomainConfig{Domain: "example.com", Labels: map[string]string{"team": "platform"}}
+	idx.Reload([]*config.FileConfig{&config.FileConfig{File: "reloaded.json"}},
+		map[*config.DomainConfig]*config.DomainConfig{apex: reloaded, shop: nil},
+		&Options{StaleLabel: true},
+	)
+	idx.SetStale(reloaded, false)
+	idx.Update(mustParseTime("2060-01-01T00:00:00-00:00"))
+
+	if _, n := idx.Render("targets.json"); n != 0 {
+		t.Errorf("got: %d targets want: none in removed file", n)
+	}
+	got := mustRender(idx, "reloaded.json")
+	want := []*target.Target{&target.Target{
+		Labels: map[string]string{
+			"__meta_certspotter_id":                   "648494876",
+			"__meta_certspotter_dns_names":            "example.com",
+			"__meta_certspotter_dns_names_unicode":    "example.com",
+			"__meta_certspotter_domain":               "example.com",
+			"__meta_certspotter_labels_team":          "platform",
+			"__meta_certspotter_stale":                "false",
+			"__meta_certspotter_not_before":           "2000-01-01T00:00:00Z",
+			"__meta_certspotter_not_before_timestamp": "946684800",
+			"__meta_certspotter_not_after":            "2100-01-01T00:00:00Z",
+			"__meta_certspotter_not_after_timestamp":  "4102444800",
+			"__meta_certspotter_lifetime_days":        "36525",
+		},
+		Targets: []string{"example.com"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %+v want: %+v", got, want)
+	}
+	if stats := idx.Stats(); stats.Issuances != 1 || stats.Targets != 1 {
+		t.Errorf("got: %+v want: 1 issuance and target", stats)
+	}
+}
+
+func TestIndexDomains(t *testing.T) {
+	apex := &config.DomainConfig{
+		Domain:            "example.com",
+		IncludeSubdomains: true,
+		Labels:            map[string]string{"team": "web"},
+	}
+	shop := &config.DomainConfig{
+		Domain: "shop.example.com",
+		Labels: map[string]string{"team": "shop"},
+	}
+	cfgs := []*config.FileConfig{&config.FileConfig{File: "targets.json"}}
+	issuance := &certspotter.Issuance{
+		ID:        "648494876",
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}
+
+	idx := NewIndex(cfgs, &Options{})
+	idx.Add(shop, []*certspotter.Issuance{issuance})
+	idx.Update(now)
+	idx.Add(apex, []*certspotter.Issuance{issuance})
+
+	got := mustRender(idx, "targets.json")
+	want := []*target.Target{&target.Target{Labels: valid(map[string]string{
+		"__meta_certspotter_id":          "648494876",
+		"__meta_certspotter_domain":      "example.com;shop.example.com",
+		"__meta_certspotter_labels_team": "shop;web",
+	})}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %+v want: %+v", got, want)
+	}
+}
+
+func TestIndexBackfill(t *testing.T) {
+	apex := &config.DomainConfig{Domain: "example.com", IncludeSubdomains: true}
+	shop := &config.DomainConfig{Domain: "shop.example.com"}
+	cfgs := []*config.FileConfig{&config.FileConfig{File: "targets.json"}}
+	issuances := []*certspotter.Issuance{&certspotter.Issuance{
+		ID:        "1",
+		DNSNames:  []string{"shop.example.com"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}, &certspotter.Issuance{
+		ID:        "2",
+		DNSNames:  []string{"www.example.com"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}}
+
+	idx := NewIndex(cfgs, &Options{})
+	idx.Add(apex, issuances)
+	idx.Update(now)
+	idx.Backfill(apex, shop, func(names []string) bool {
+		return len(names) != 0 && names[0] == shop.Domain
+	})
+
+	got := make(map[string]string)
+	for _, tg := range mustRender(idx, "targets.json") {
+		got[tg.Labels["__meta_certspotter_id"]] = tg.Labels["__meta_certspotter_domain"]
+	}
+	want := map[string]string{"1": "example.com;shop.example.com", "2": "example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v want: %v", got, want)
+	}
+}
+
+func TestIndexDaysRemaining(t *testing.T) {
+	dom := &config.DomainConfig{Domain: "example.com"}
+	cfgs := []*config.FileConfig{
+		&config.FileConfig{File: "all.json", DaysRemaining: true},
+		&config.FileConfig{
+			File:          "expiring.json",
+			DaysRemaining: true,
+			MatchRE:       config.MatchRE{"days_remaining": regexp.MustCompile("^[0-6]$")},
+		},
+		&config.FileConfig{File: "unlabeled.json"},
+	}
+
+	idx := NewIndex(cfgs, &Options{})
+	idx.Add(dom, []*certspotter.Issuance{&certspotter.Issuance{
+		ID:        "648494876",
+		NotBefore: mustParseTime("2020-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2020-03-30T23:59:59-00:00"),
+	}})
+
+	table := []struct {
+		now      time.Time
+		days     string
+		expiring int
+	}{
+		{mustParseTime("2020-01-01T00:00:00-00:00"), "89", 0},
+		{mustParseTime("2020-03-23T00:00:00-00:00"), "7", 0},
+		{mustParseTime("2020-03-23T23:59:59-00:00"), "7", 0},
+		{mustParseTime("2020-03-24T00:00:00-00:00"), "6", 1},
+		{mustParseTime("2020-03-30T12:00:00-00:00"), "0", 1},
+	}
+
+	for _, test := range table {
+		t.Logf("testing: %s", test.now)
+
+		idx.Update(test.now)
+		got := mustRender(idx, "all.json")[0].Labels["__meta_certspotter_days_remaining"]
+		if got != test.days {
+			t.Errorf("got: %s want: %s", got, test.days)
+		}
+		if _, n := idx.Render("expiring.json"); n != test.expiring {
+			t.Errorf("got: %d want: %d", n, test.expiring)
+		}
+		if label, ok := mustRender(idx, "unlabeled.json")[0].Labels["__meta_certspotter_days_remaining"]; ok {
+			t.Errorf("got: %s want: no days remaining label", label)
+		}
+	}
+}
+
+func TestEntriesIndex(t *testing.T) {
+	es := entries{by: next, index: agingIndex}
+	var all []*entry
+	for n := 0; n < 8; n++ {
+		e := &entry{next: now.Add(time.Duration(n) * time.Hour), aging: -1}
+		all = append(all, e)
+		heap.Push(&es, e)
+	}
+
+	// moving entries must keep the heap ordered
+	all[0].next = now.Add(24 * time.Hour)
+	heap.Fix(&es, all[0].aging)
+	all[7].next = now.Add(-time.Hour)
+	heap.Fix(&es, all[7].aging)
+
+	var got []*entry
+	for es.Len() > 0 {
+		e := heap.Pop(&es).(*entry)
+		if e.aging != -1 {
+			t.Errorf("got: index %d want: -1 after pop", e.aging)
+		}
+		got = append(got, e)
+	}
+	want := append(append([]*entry{all[7]}, all[1:7]...), all[0])
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v want: %v", got, want)
+	}
+}
+
+func TestIndexSetStale(t *testing.T) {
+	dom := &config.DomainConfig{Domain: "example.com"}
+	cfgs := []*config.FileConfig{&config.FileConfig{File: "targets.json"}}
+
+	idx := NewIndex(cfgs, &Options{StaleLabel: true})
+	idx.Add(dom, []*certspotter.Issuance{&certspotter.Issuance{
+		ID:        "648494876",
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}})
+	idx.Update(now)
+
+	for _, stale := range []string{"true", "false", "true"} {
+		t.Logf("testing: %s", stale)
+
+		idx.SetStale(dom, stale == "true")
+		got := mustRender(idx, "targets.json")[0].Labels["__meta_certspotter_stale"]
+		if got != stale {
+			t.Errorf("got: %s want: %s", got, stale)
+		}
+	}
+}
+
+// issuances returns num valid issuances spread over subdomains of example.com.
+func issuances(num int) []*certspotter.Issuance {
+	is := make([]*certspotter.Issuance, num)
+	for n := range is {
+		is[n] = &certspotter.Issuance{
+			ID:        fmt.Sprintf("%010d", n),
+			DNSNames:  []string{fmt.Sprintf("host%d.team%d.example.com", n, n%100)},
+			NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+			NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+			Issuer:    &certspotter.Issuer{Name: fmt.Sprintf("CN=Issuer %d", n%10)},
+			Certificate: &certspotter.Certificate{
+				SHA256: fmt.Sprintf("%064x", n),
+				Type:   "cert",
+			},
+		}
+	}
+	return is
+}
+
+func benchmarkIndex(b *testing.B, num int) {
+	dom := &config.DomainConfig{Domain: "example.com", IncludeSubdomains: true}
+	cfgs := []*config.FileConfig{
+		&config.FileConfig{File: "all.json"},
+		&config.FileConfig{
+			File:    "team.json",
+			MatchRE: config.MatchRE{"dns_names": regexp.MustCompile(`^.*\.team1\.example\.com$`)},
+		},
+	}
+
+	all := issuances(num + b.N)
+	idx := NewIndex(cfgs, &Options{})
+	idx.Add(dom, all[:num])
+	idx.Update(time.Now())
+	idx.Render("all.json")
+	idx.Render("team.json")
+
+	next := all[num:]
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		idx.Add(dom, next[n:n+1])
+		idx.Update(time.Now())
+		idx.Render("all.json")
+		idx.Render("team.json")
+	}
+}
+
+func BenchmarkIndex10k(b *testing.B)  { benchmarkIndex(b, 10000) }
+func BenchmarkIndex100k(b *testing.B) { benchmarkIndex(b, 100000) }
+func BenchmarkIndex1M(b *testing.B)   { benchmarkIndex(b, 1000000) }
+
+func TestIndexDropped(t *testing.T) {
+	dom := &config.DomainConfig{Domain: "example.com"}
+	cfgs := []*config.FileConfig{&config.FileConfig{
+		File:            "targets.json",
+		AddressTemplate: config.MustNewTemplate(`{{if ne .Host "www.example.com"}}{{.Host}}{{end}}`),
+	}}
+
+	idx := NewIndex(cfgs, &Options{})
+	idx.Add(dom, []*certspotter.Issuance{&certspotter.Issuance{
+		ID:        "648494876",
+		DNSNames:  []string{"example.com", "www.example.com"},
+		NotBefore: mustParseTime("2000-01-01T00:00:00-00:00"),
+		NotAfter:  mustParseTime("2100-01-01T00:00:00-00:00"),
+	}})
+	idx.Update(now)
+
+	if _, n := idx.Render("targets.json"); n != 1 {
+		t.Errorf("got: %d targets want: 1", n)
+	}
+	if n, err := idx.Dropped("targets.json"); n != 1 || err == nil {
+		t.Errorf("got: %d dropped (%v) want: 1 dropped with error", n, err)
+	}
+
+	idx.Update(mustParseTime("2101-01-01T00:00:00-00:00"))
+	if n, err := idx.Dropped("targets.json"); n != 0 {
+		t.Errorf("got: %d dropped (%v) want: none after expiry", n, err)
+	}
+}
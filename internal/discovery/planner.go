@@ -0,0 +1,128 @@
+package discovery
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codecentric/certspotter-sd/internal/certspotter"
+	"github.com/codecentric/certspotter-sd/internal/config"
+	"github.com/codecentric/certspotter-sd/internal/discovery/client"
+)
+
+// query is a certspotter api query of a domain collecting issuances for the
+// domains it covers as well.
+type query struct {
+	domain  *domain
+	covered []*domain
+	opts    *client.SubOptions
+}
+
+// key identifies the domains and polling options of query. Subscriptions
+// are restarted if the key of their query changed.
+func (q *query) key() string {
+	names := make([]string, 0, len(q.covered))
+	for _, dom := range q.covered {
+		names = append(names, dom.cfg.Domain)
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("%s %t %s %s %d %s", q.domain.cfg.Domain, q.domain.cfg.IncludeSubdomains,
+		q.opts.Interval, q.opts.MaxInterval, q.opts.Priority, strings.Join(names, ","))
+}
+
+// members returns the queried domain followed by its covered domains.
+func (q *query) members() []*domain {
+	return append([]*domain{q.domain}, q.covered...)
+}
+
+// plan returns the queries of domains. Domains covered by another domain
+// including sub domains are collapsed into the query of the topmost covering
+// domain, which polls at the shortest interval and highest priority of its
+// domains. Interval is used for domains without polling interval.
+func plan(domains []*domain, interval time.Duration) []*query {
+	queries := make(map[*domain]*query)
+	var ordered []*query
+	for _, dom := range domains {
+		if covering(domains, dom) == nil {
+			q := &query{domain: dom}
+			queries[dom] = q
+			ordered = append(ordered, q)
+		}
+	}
+	for _, dom := range domains {
+		if c := covering(domains, dom); c != nil {
+			queries[c].covered = append(queries[c].covered, dom)
+		}
+	}
+
+	for _, q := range ordered {
+		q.opts = &client.SubOptions{Interval: effectiveInterval(q.domain.cfg, interval), Priority: q.domain.cfg.Priority}
+		for _, dom := range q.members() {
+			if iv := effectiveInterval(dom.cfg, interval); iv < q.opts.Interval {
+				q.opts.Interval = iv
+			}
+			if dom.cfg.Priority > q.opts.Priority {
+				q.opts.Priority = dom.cfg.Priority
+			}
+			// adapted intervals don't exceed configured polling intervals
+			if iv := dom.cfg.Interval; iv > 0 && (q.opts.MaxInterval == 0 || iv < q.opts.MaxInterval) {
+				q.opts.MaxInterval = iv
+			}
+			q.opts.Domains = append(q.opts.Domains, dom.cfg.Domain)
+		}
+	}
+	return ordered
+}
+
+// covering returns the topmost domain covering dom including sub domains
+// or nil.
+func covering(domains []*domain, dom *domain) *domain {
+	var top *domain
+	for _, c := range domains {
+		if c == dom || !covers(c.cfg, dom.cfg.Domain) {
+			continue
+		}
+		if top == nil || len(c.cfg.Domain) < len(top.cfg.Domain) {
+			top = c
+		}
+	}
+	return top
+}
+
+// covers returns if cfg includes sub domain name.
+func covers(cfg *config.DomainConfig, name string) bool {
+	return cfg.IncludeSubdomains && strings.HasSuffix(name, "."+cfg.Domain)
+}
+
+// effectiveInterval returns the polling interval of cfg or interval.
+func effectiveInterval(cfg *config.DomainConfig, interval time.Duration) time.Duration {
+	if cfg.Interval > 0 {
+		return cfg.Interval
+	}
+	return interval
+}
+
+// route returns the issuances the certspotter api returns for cfg.
+func route(cfg *config.DomainConfig, issuances []*certspotter.Issuance) []*certspotter.Issuance {
+	var routed []*certspotter.Issuance
+	for _, issuance := range issuances {
+		if routes(cfg, issuance.DNSNames) {
+			routed = append(routed, issuance)
+		}
+	}
+	return routed
+}
+
+// routes returns if the certspotter api returns issuances with names for
+// cfg. These are valid for the domain or, if sub domains are included, for
+// any of its sub domains. Wildcards covering the domain are not matched.
+func routes(cfg *config.DomainConfig, names []string) bool {
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if name == cfg.Domain || covers(cfg, name) {
+			return true
+		}
+	}
+	return false
+}
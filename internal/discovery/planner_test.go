@@ -0,0 +1,129 @@
+package discovery
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/codecentric/certspotter-sd/internal/certspotter"
+	"github.com/codecentric/certspotter-sd/internal/config"
+	"github.com/codecentric/certspotter-sd/internal/discovery/client"
+)
+
+func TestPlan(t *testing.T) {
+	table := map[string]struct {
+		cfgs []*config.DomainConfig
+		want map[string][]string
+	}{"distinct domains": {
+		[]*config.DomainConfig{
+			&config.DomainConfig{Domain: "example.com", IncludeSubdomains: true},
+			&config.DomainConfig{Domain: "example.org", IncludeSubdomains: true},
+		},
+		map[string][]string{"example.com": nil, "example.org": nil},
+	}, "covered domains": {
+		[]*config.DomainConfig{
+			&config.DomainConfig{Domain: "shop.example.com"},
+			&config.DomainConfig{Domain: "example.com", IncludeSubdomains: true},
+			&config.DomainConfig{Domain: "api.shop.example.com"},
+		},
+		map[string][]string{"example.com": []string{"shop.example.com", "api.shop.example.com"}},
+	}, "nested covering domains": {
+		[]*config.DomainConfig{
+			&config.DomainConfig{Domain: "example.com", IncludeSubdomains: true},
+			&config.DomainConfig{Domain: "shop.example.com", IncludeSubdomains: true},
+			&config.DomainConfig{Domain: "api.shop.example.com"},
+		},
+		map[string][]string{"example.com": []string{"shop.example.com", "api.shop.example.com"}},
+	}, "domain without sub domains": {
+		[]*config.DomainConfig{
+			&config.DomainConfig{Domain: "example.com"},
+			&config.DomainConfig{Domain: "shop.example.com"},
+		},
+		map[string][]string{"example.com": nil, "shop.example.com": nil},
+	}, "label boundary": {
+		[]*config.DomainConfig{
+			&config.DomainConfig{Domain: "example.com", IncludeSubdomains: true},
+			&config.DomainConfig{Domain: "myexample.com"},
+		},
+		map[string][]string{"example.com": nil, "myexample.com": nil},
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		var domains []*domain
+		for _, cfg := range test.cfgs {
+			domains = append(domains, &domain{cfg: cfg})
+		}
+		got := make(map[string][]string)
+		for _, q := range plan(domains, time.Hour) {
+			var covered []string
+			for _, dom := range q.covered {
+				covered = append(covered, dom.cfg.Domain)
+			}
+			got[q.domain.cfg.Domain] = covered
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got: %v want: %v", got, test.want)
+		}
+	}
+}
+
+func TestPlanOptions(t *testing.T) {
+	domains := []*domain{
+		&domain{cfg: &config.DomainConfig{Domain: "example.com", IncludeSubdomains: true, Priority: 1}},
+		&domain{cfg: &config.DomainConfig{Domain: "shop.example.com", Interval: 5 * time.Minute}},
+		&domain{cfg: &config.DomainConfig{Domain: "api.example.com", Interval: 2 * time.Hour, Priority: 10}},
+	}
+
+	queries := plan(domains, time.Hour)
+	if len(queries) != 1 {
+		t.Fatalf("got: %d queries want: 1", len(queries))
+	}
+	want := &client.SubOptions{
+		Interval:    5 * time.Minute,
+		Priority:    10,
+		MaxInterval: 5 * time.Minute,
+		Domains:     []string{"example.com", "shop.example.com", "api.example.com"},
+	}
+	if got := queries[0].opts; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %+v want: %+v", got, want)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	issuances := []*certspotter.Issuance{
+		&certspotter.Issuance{ID: "1", DNSNames: []string{"example.com"}},
+		&certspotter.Issuance{ID: "2", DNSNames: []string{"shop.example.com"}},
+		&certspotter.Issuance{ID: "3", DNSNames: []string{"*.example.com"}},
+		&certspotter.Issuance{ID: "4", DNSNames: []string{"api.shop.example.com"}},
+		&certspotter.Issuance{ID: "5", DNSNames: []string{"www.example.com", "SHOP.example.com"}},
+		&certspotter.Issuance{ID: "6", DNSNames: []string{"myshop.example.com"}},
+	}
+
+	table := map[string]struct {
+		cfg  *config.DomainConfig
+		want []string
+	}{"domain": {
+		&config.DomainConfig{Domain: "shop.example.com"},
+		[]string{"2", "5"},
+	}, "domain including sub domains": {
+		&config.DomainConfig{Domain: "shop.example.com", IncludeSubdomains: true},
+		[]string{"2", "4", "5"},
+	}, "unknown domain": {
+		&config.DomainConfig{Domain: "example.org"},
+		nil,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		var got []string
+		for _, issuance := range route(test.cfg, issuances) {
+			got = append(got, issuance.ID)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got: %v want: %v", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package record
+
+import (
+	"sync"
+
+	"github.com/codecentric/certspotter-sd/internal/certspotter"
+)
+
+// Interner deduplicates repeating strings and issuers. Interned values are
+// never released, as names and issuers repeat across certificate renewals.
+type Interner struct {
+	mtx     sync.Mutex
+	strings map[string]string
+	issuers map[certspotter.Issuer]*certspotter.Issuer
+	bytes   int
+}
+
+// NewInterner returns a new interner.
+func NewInterner() *Interner {
+	return &Interner{
+		strings: make(map[string]string),
+		issuers: make(map[certspotter.Issuer]*certspotter.Issuer),
+	}
+}
+
+// Intern returns the interned copy of str.
+func (in *Interner) Intern(str string) string {
+	in.mtx.Lock()
+	defer in.mtx.Unlock()
+
+	if interned, ok := in.strings[str]; ok {
+		return interned
+	}
+	in.strings[str] = str
+	in.bytes += len(str)
+	return str
+}
+
+// Issuer returns the interned copy of issuer.
+func (in *Interner) Issuer(issuer *certspotter.Issuer) *certspotter.Issuer {
+	if issuer == nil {
+		return nil
+	}
+
+	in.mtx.Lock()
+	defer in.mtx.Unlock()
+
+	if interned, ok := in.issuers[*issuer]; ok {
+		return interned
+	}
+	interned := &certspotter.Issuer{Name: issuer.Name, PubKeySHA256: issuer.PubKeySHA256}
+	in.issuers[*issuer] = interned
+	in.bytes += len(issuer.Name) + len(issuer.PubKeySHA256)
+	return interned
+}
+
+// Len returns the number of interned strings and issuers and their size in
+// bytes.
+func (in *Interner) Len() (num int, bytes int) {
+	in.mtx.Lock()
+	defer in.mtx.Unlock()
+
+	return len(in.strings) + len(in.issuers), in.bytes
+}
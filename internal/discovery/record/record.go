@@ -0,0 +1,140 @@
+package record
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"time"
+	"unsafe"
+
+	"github.com/codecentric/certspotter-sd/internal/certspotter"
+)
+
+// Record is a compact in-memory representation of a certspotter issuance.
+// Certificate data is dropped after deriving x509 fields at ingest and
+// repeating strings are interned.
+type Record struct {
+	ID       string
+	DNSNames []string
+	// IPAddresses from dns names and certificate data.
+	IPAddresses []string
+	// Emails and URIs derived from certificate data.
+	Emails       []string
+	URIs         []string
+	TBSSHA256    Hash
+	PubKeySHA256 Hash
+	NotBefore    time.Time
+	NotAfter     time.Time
+	Issuer       *certspotter.Issuer
+	Cert         *Cert
+}
+
+// Cert holds certificate fields of a record.
+type Cert struct {
+	Type   string
+	SHA256 Hash
+}
+
+// Hash is a hex encoded hash stored in binary form. Hashes which aren't hex
+// encoded are stored as is.
+type Hash struct {
+	data   string
+	binary bool
+}
+
+// NewHash returns a hash from hex string or str itself if it is no valid hex
+// string.
+func NewHash(str string) Hash {
+	data, err := hex.DecodeString(str)
+	if err != nil || str == "" {
+		return Hash{data: str}
+	}
+	return Hash{data: string(data), binary: true}
+}
+
+// String returns the hex encoded hash.
+func (h Hash) String() string {
+	if !h.binary {
+		return h.data
+	}
+	return hex.EncodeToString([]byte(h.data))
+}
+
+// Len returns the number of bytes used by the hash.
+func (h Hash) Len() int {
+	return len(h.data)
+}
+
+// New returns a compact record for issuance with strings interned by in.
+func New(issuance *certspotter.Issuance, in *Interner) *Record {
+	rec := &Record{
+		ID:           issuance.ID,
+		TBSSHA256:    NewHash(issuance.TBSSHA256),
+		PubKeySHA256: NewHash(issuance.PubKeySHA256),
+		NotBefore:    issuance.NotBefore,
+		NotAfter:     issuance.NotAfter,
+		Issuer:       in.Issuer(issuance.Issuer),
+	}
+
+	for _, name := range issuance.DNSNames {
+		if ip := net.ParseIP(name); ip != nil {
+			rec.addIP(ip)
+			continue
+		}
+		rec.DNSNames = append(rec.DNSNames, in.Intern(name))
+	}
+
+	if issuance.Certificate != nil {
+		rec.Cert = &Cert{
+			Type:   in.Intern(issuance.Certificate.Type),
+			SHA256: NewHash(issuance.Certificate.SHA256),
+		}
+		if cert, err := Parse(issuance.Certificate.Data); err == nil {
+			for _, ip := range cert.IPAddresses {
+				rec.addIP(ip)
+			}
+			for _, email := range cert.EmailAddresses {
+				rec.Emails = append(rec.Emails, in.Intern(email))
+			}
+			for _, uri := range cert.URIs {
+				rec.URIs = append(rec.URIs, in.Intern(uri.String()))
+			}
+		}
+	}
+	return rec
+}
+
+// addIP adds ip to record unless it is known already.
+func (r *Record) addIP(ip net.IP) {
+	addr := ip.String()
+	for _, known := range r.IPAddresses {
+		if known == addr {
+			return
+		}
+	}
+	r.IPAddresses = append(r.IPAddresses, addr)
+}
+
+// Parse parses base64 encoded certificate data.
+func Parse(data string) (*x509.Certificate, error) {
+	der, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// Size returns the approximate number of bytes used by record, excluding
+// interned strings.
+func (r *Record) Size() int {
+	size := int(unsafe.Sizeof(*r)) + len(r.ID) + r.TBSSHA256.Len() + r.PubKeySHA256.Len()
+	size += (len(r.DNSNames) + len(r.Emails) + len(r.URIs)) * int(unsafe.Sizeof(""))
+	for _, ip := range r.IPAddresses {
+		size += int(unsafe.Sizeof("")) + len(ip)
+	}
+	if r.Cert != nil {
+		size += int(unsafe.Sizeof(*r.Cert)) + r.Cert.SHA256.Len()
+	}
+	return size
+}
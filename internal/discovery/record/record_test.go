@@ -0,0 +1,165 @@
+package record
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/codecentric/certspotter-sd/internal/certspotter"
+)
+
+const certData = "MIIHQDCCBiigAwIBAgIQD9B43Ujxor1NDyupa2A4/jANBgkqhkiG9w0BAQsFADBNMQswCQYDVQQGEwJVUzEVMBMGA1UEChMMRGlnaUNlcnQgSW5jMScwJQYDVQQDEx5EaWdpQ2VydCBTSEEyIFNlY3VyZSBTZXJ2ZXIgQ0EwHhcNMTgxMTI4MDAwMDAwWhcNMjAxMjAyMTIwMDAwWjCBpTELMAkGA1UEBhMCVVMxEzARBgNVBAgTCkNhbGlmb3JuaWExFDASBgNVBAcTC0xvcyBBbmdlbGVzMTwwOgYDVQQKEzNJbnRlcm5ldCBDb3Jwb3JhdGlvbiBmb3IgQXNzaWduZWQgTmFtZXMgYW5kIE51bWJlcnMxEzARBgNVBAsTClRlY2hub2xvZ3kxGDAWBgNVBAMTD3d3dy5leGFtcGxlLm9yZzCCASIwDQYJKoZIhvcNAQEBBQADggEPADCCAQoCggEBANDwEnSgliByCGUZElpdStA6jGaPoCkrp9vVrAzPpXGSFUIVsAeSdjF11yeOTVBqddF7U14nqu3rpGA68o5FGGtFM1yFEaogEv5grJ1MRY/d0w4+dw8JwoVlNMci+3QTuUKf9yH28JxEdG3J37Mfj2C3cREGkGNBnY80eyRJRqzy8I0LSPTTkhr3okXuzOXXg38ugr1x3SgZWDNuEaE6oGpyYJIBWZ9jF3pJQnucP9vTBejMh374qvyd0QVQq3WxHrogy4nUbWw3gihMxT98wRD1oKVma1NTydvthcNtBfhkp8kO64/hxLHrLWgOFT/l4tz8IWQt7mkrBHjbd2XLVPkCAwEAAaOCA8EwggO9MB8GA1UdIwQYMBaAFA+AYRyCMWHVLyjnjUY4tCzhxtniMB0GA1UdDgQWBBRmmGIC4AmRp9njNvt2xrC/oW2nvjCBgQYDVR0RBHoweIIPd3d3LmV4YW1wbGUub3JnggtleGFtcGxlLmNvbYILZXhhbXBsZS5lZHWCC2V4YW1wbGUubmV0ggtleGFtcGxlLm9yZ4IPd3d3LmV4YW1wbGUuY29tgg93d3cuZXhhbXBsZS5lZHWCD3d3dy5leGFtcGxlLm5ldDAOBgNVHQ8BAf8EBAMCBaAwHQYDVR0lBBYwFAYIKwYBBQUHAwEGCCsGAQUFBwMCMGsGA1UdHwRkMGIwL6AtoCuGKWh0dHA6Ly9jcmwzLmRpZ2ljZXJ0LmNvbS9zc2NhLXNoYTItZzYuY3JsMC+gLaArhilodHRwOi8vY3JsNC5kaWdpY2VydC5jb20vc3NjYS1zaGEyLWc2LmNybDBMBgNVHSAERTBDMDcGCWCGSAGG/WwBATAqMCgGCCsGAQUFBwIBFhxodHRwczovL3d3dy5kaWdpY2VydC5jb20vQ1BTMAgGBmeBDAECAjB8BggrBgEFBQcBAQRwMG4wJAYIKwYBBQUHMAGGGGh0dHA6Ly9vY3NwLmRpZ2ljZXJ0LmNvbTBGBggrBgEFBQcwAoY6aHR0cDovL2NhY2VydHMuZGlnaWNlcnQuY29tL0RpZ2lDZXJ0U0hBMlNlY3VyZVNlcnZlckNBLmNydDAMBgNVHRMBAf8EAjAAMIIBfwYKKwYBBAHWeQIEAgSCAW8EggFrAWkAdwCkuQmQtBhYFIe7E6LMZ3AKPDWYBPkb37jjd80OyA3cEAAAAWdcMZVGAAAEAwBIMEYCIQCEZIG3IR36Gkj1dq5L6EaGVycXsHvpO7dKV0JsooTEbAIhALuTtf4wxGTkFkx8blhTV+7sf6pFT78ORo7+cP39jkJCAHYAh3W/51l8+IxDmV+9827/Vo1HVjb/SrVgwbTq/16ggw8AAAFnXDGWFQAABAMARzBFAiBvqnfSHKeUwGMtLrOG3UGLQIoaL3+uZsGTX3MfSJNQEQIhANL5nUiGBR6gl0QlCzzqzvorGXyB/yd7nttYttzo8EpOAHYAb1N2rDHwMRnYmQCkURX/dxUcEdkCwQApBo2yCJo32RMAAAFnXDGWnAAABAMARzBFAiEA5Hn7Q4SOyqHkT+kDsHq7ku7zRDuM7P4UDX2ft2Mpny0CIE13WtxJAUr0aASFYZ/XjSAMMfrB0/RxClvWVss9LHKMMA0GCSqGSIb3DQEBCwUAA4IBAQBzcIXvQEGnakPVeJx7VUjmvGuZhrr7DQOLeP4R8CmgDM1pFAvGBHiyzvCH1QGdxFl6cf7wbp7BoLCRLR/qPVXFMwUMzcE1GLBqaGZMv1Yh2lvZSLmMNSGRXdx113pGLCInpm/TOhfrvr0TxRImc8BdozWJavsn1N2qdHQuN+UBO6bQMLCD0KHEdSGFsuX6ZwAworxTg02/1qiDu7zW7RyzHvFYA4IAjpzvkPIaX6KjBtpdvp/aXabmL95YgBjT8WJ7pqOfrqhpcmOBZa6Cg6O1l4qbIFH/Gj9hQB5I0Gs4+eH6F9h3SojmPTYkT+8KuZ9w84Mn+M8qBXUQoYoKgIjN"
+
+func mustParseTime(str string) time.Time {
+	time, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		panic(err)
+	}
+	return time
+}
+
+// mustCreateCert returns base64 encoded data of a self signed certificate.
+func mustCreateCert(tmpl *x509.Certificate) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+func TestNew(t *testing.T) {
+	sans := mustCreateCert(&x509.Certificate{
+		SerialNumber:   big.NewInt(42),
+		DNSNames:       []string{"example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")},
+		EmailAddresses: []string{"admin@example.com"},
+		URIs:           []*url.URL{&url.URL{Scheme: "spiffe", Host: "example.com", Path: "/web"}},
+	})
+
+	table := map[string]struct {
+		issuance *certspotter.Issuance
+		want     *Record
+	}{"empty issuance": {
+		&certspotter.Issuance{},
+		&Record{},
+	}, "complete issuance": {
+		&certspotter.Issuance{
+			ID:           "648494876",
+			DNSNames:     []string{"example.com", "www.example.org"},
+			TBSSHA256:    "b0537995114358761f330303e5b8a0d7c7319a7e458495395e07004911f91c38",
+			PubKeySHA256: "8bd1da95272f7fa4ffb24137fc0ed03aae67e5c4d8b3c50734e1050a7920b922",
+			NotBefore:    mustParseTime("2018-11-28T00:00:00-00:00"),
+			NotAfter:     mustParseTime("2020-12-02T12:00:00-00:00"),
+			Issuer: &certspotter.Issuer{
+				Name: "C=US, O=DigiCert Inc, CN=DigiCert SHA2 Secure Server CA",
+			},
+			Certificate: &certspotter.Certificate{
+				Type:   "cert",
+				SHA256: "9250711c54de546f4370e0c3d3a3ec45bc96092a25a4a71a1afa396af7047eb8",
+				Data:   certData,
+			},
+		},
+		&Record{
+			ID:           "648494876",
+			DNSNames:     []string{"example.com", "www.example.org"},
+			TBSSHA256:    NewHash("b0537995114358761f330303e5b8a0d7c7319a7e458495395e07004911f91c38"),
+			PubKeySHA256: NewHash("8bd1da95272f7fa4ffb24137fc0ed03aae67e5c4d8b3c50734e1050a7920b922"),
+			NotBefore:    mustParseTime("2018-11-28T00:00:00-00:00"),
+			NotAfter:     mustParseTime("2020-12-02T12:00:00-00:00"),
+			Issuer: &certspotter.Issuer{
+				Name: "C=US, O=DigiCert Inc, CN=DigiCert SHA2 Secure Server CA",
+			},
+			Cert: &Cert{
+				Type:   "cert",
+				SHA256: NewHash("9250711c54de546f4370e0c3d3a3ec45bc96092a25a4a71a1afa396af7047eb8"),
+			},
+		},
+	}, "ip addresses and other sans": {
+		&certspotter.Issuance{
+			ID:          "648494876",
+			DNSNames:    []string{"example.com", "192.0.2.1", "2001:0db8::0001"},
+			Certificate: &certspotter.Certificate{Type: "cert", Data: sans},
+		},
+		&Record{
+			ID:          "648494876",
+			DNSNames:    []string{"example.com"},
+			IPAddresses: []string{"192.0.2.1", "2001:db8::1"},
+			Emails:      []string{"admin@example.com"},
+			URIs:        []string{"spiffe://example.com/web"},
+			Cert:        &Cert{Type: "cert"},
+		},
+	}, "malformed cert data": {
+		&certspotter.Issuance{
+			ID: "648494876",
+			Certificate: &certspotter.Certificate{
+				Type: "precert",
+				Data: "malformed",
+			},
+		},
+		&Record{
+			ID:   "648494876",
+			Cert: &Cert{Type: "precert"},
+		},
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		got := New(test.issuance, NewInterner())
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got: %#v want: %#v", got, test.want)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	table := map[string]struct {
+		str  string
+		want string
+		size int
+	}{"sha256": {
+		"9250711c54de546f4370e0c3d3a3ec45bc96092a25a4a71a1afa396af7047eb8",
+		"9250711c54de546f4370e0c3d3a3ec45bc96092a25a4a71a1afa396af7047eb8",
+		32,
+	}, "empty": {
+		"", "", 0,
+	}, "malformed": {
+		"malformed", "malformed", 9,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		got := NewHash(test.str)
+		if got.String() != test.want || got.Len() != test.size {
+			t.Errorf("got: %s (%d bytes) want: %s (%d bytes)", got, got.Len(), test.want, test.size)
+		}
+	}
+}
+
+func TestInterner(t *testing.T) {
+	in := NewInterner()
+	issuer := &certspotter.Issuer{Name: "CN=DigiCert SHA2 Secure Server CA"}
+
+	a := New(&certspotter.Issuance{ID: "1", DNSNames: []string{"example.com"}, Issuer: issuer}, in)
+	b := New(&certspotter.Issuance{ID: "2", DNSNames: []string{"example.com"}, Issuer: issuer}, in)
+
+	if a.Issuer != b.Issuer {
+		t.Errorf("got: distinct issuers want: interned issuer")
+	}
+	if num, bytes := in.Len(); num != 2 || bytes != len("example.com")+len(issuer.Name) {
+		t.Errorf("got: %d strings %d bytes want: 2 strings %d bytes", num, bytes, len("example.com")+len(issuer.Name))
+	}
+}
@@ -0,0 +1,100 @@
+package relabel
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/codecentric/certspotter-sd/internal/config"
+)
+
+// Process applies relabel configurations to a copy of labels. It returns nil
+// if labels were dropped.
+func Process(labels map[string]string, cfgs ...*config.RelabelConfig) map[string]string {
+	out := make(map[string]string, len(labels))
+	for name, val := range labels {
+		out[name] = val
+	}
+	for _, cfg := range cfgs {
+		if !relabel(out, cfg) {
+			return nil
+		}
+	}
+	return out
+}
+
+// relabel applies cfg to labels and returns if labels should be kept.
+func relabel(labels map[string]string, cfg *config.RelabelConfig) bool {
+	vals := make([]string, len(cfg.SourceLabels))
+	for i, name := range cfg.SourceLabels {
+		vals[i] = labels[name]
+	}
+	val := strings.Join(vals, cfg.Separator)
+
+	switch cfg.Action {
+	case config.RelabelKeep:
+		return cfg.Regex.MatchString(val)
+	case config.RelabelDrop:
+		return !cfg.Regex.MatchString(val)
+	case config.RelabelKeepEqual:
+		return labels[cfg.TargetLabel] == val
+	case config.RelabelReplace:
+		indexes := cfg.Regex.FindStringSubmatchIndex(val)
+		if indexes == nil {
+			break
+		}
+		target := string(cfg.Regex.ExpandString(nil, cfg.TargetLabel, val, indexes))
+		if !isLabelName(target) {
+			break
+		}
+		res := cfg.Regex.ExpandString(nil, cfg.Replacement, val, indexes)
+		if len(res) == 0 {
+			delete(labels, target)
+			break
+		}
+		labels[target] = string(res)
+	case config.RelabelLowercase:
+		labels[cfg.TargetLabel] = strings.ToLower(val)
+	case config.RelabelHashMod:
+		sum := md5.Sum([]byte(val))
+		mod := binary.BigEndian.Uint64(sum[md5.Size-8:]) % cfg.Modulus
+		labels[cfg.TargetLabel] = strconv.FormatUint(mod, 10)
+	case config.RelabelLabelMap:
+		mapped := make(map[string]string)
+		for name, val := range labels {
+			if cfg.Regex.MatchString(name) {
+				mapped[cfg.Regex.ReplaceAllString(name, cfg.Replacement)] = val
+			}
+		}
+		for name, val := range mapped {
+			labels[name] = val
+		}
+	case config.RelabelLabelDrop:
+		for name := range labels {
+			if cfg.Regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	case config.RelabelLabelKeep:
+		for name := range labels {
+			if !cfg.Regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	}
+	return true
+}
+
+// isLabelName returns if name is a valid label name.
+func isLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || r >= '0' && r <= '9' && i > 0) {
+			return false
+		}
+	}
+	return true
+}
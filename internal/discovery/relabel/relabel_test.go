@@ -0,0 +1,163 @@
+package relabel
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/codecentric/certspotter-sd/internal/config"
+)
+
+func TestProcess(t *testing.T) {
+	labels := map[string]string{
+		"__address__":                     "www.example.com",
+		"__meta_certspotter_id":           "648494876",
+		"__meta_certspotter_labels_owner": "Team-A",
+	}
+
+	table := map[string]struct {
+		cfg  config.RelabelConfig
+		want map[string]string
+	}{"replace": {
+		config.RelabelConfig{
+			SourceLabels: []string{"__address__"},
+			Regex:        config.MustNewRegexp(`www\.(.*)`),
+			TargetLabel:  "domain",
+			Replacement:  "$1",
+			Action:       config.RelabelReplace,
+		},
+		map[string]string{
+			"__address__":                     "www.example.com",
+			"__meta_certspotter_id":           "648494876",
+			"__meta_certspotter_labels_owner": "Team-A",
+			"domain":                          "example.com",
+		},
+	}, "replace without match": {
+		config.RelabelConfig{
+			SourceLabels: []string{"__address__"},
+			Regex:        config.MustNewRegexp(`mail\.(.*)`),
+			TargetLabel:  "domain",
+			Replacement:  "$1",
+			Action:       config.RelabelReplace,
+		},
+		labels,
+	}, "replace empty deletes": {
+		config.RelabelConfig{
+			SourceLabels: []string{"missing"},
+			Regex:        config.MustNewRegexp(`(.*)`),
+			TargetLabel:  "__meta_certspotter_id",
+			Replacement:  "$1",
+			Action:       config.RelabelReplace,
+		},
+		map[string]string{
+			"__address__":                     "www.example.com",
+			"__meta_certspotter_labels_owner": "Team-A",
+		},
+	}, "replace param module": {
+		config.RelabelConfig{
+			Separator:   ";",
+			Regex:       config.MustNewRegexp(`(.*)`),
+			TargetLabel: "__param_module",
+			Replacement: "http_2xx",
+			Action:      config.RelabelReplace,
+		},
+		map[string]string{
+			"__address__":                     "www.example.com",
+			"__meta_certspotter_id":           "648494876",
+			"__meta_certspotter_labels_owner": "Team-A",
+			"__param_module":                  "http_2xx",
+		},
+	}, "keep": {
+		config.RelabelConfig{
+			SourceLabels: []string{"__address__"},
+			Regex:        config.MustNewRegexp(`.*\.example\.com`),
+			Action:       config.RelabelKeep,
+		},
+		labels,
+	}, "keep drops": {
+		config.RelabelConfig{
+			SourceLabels: []string{"__address__"},
+			Regex:        config.MustNewRegexp(`example\.com`),
+			Action:       config.RelabelKeep,
+		},
+		nil,
+	}, "drop": {
+		config.RelabelConfig{
+			SourceLabels: []string{"__address__"},
+			Regex:        config.MustNewRegexp(`www\..*`),
+			Action:       config.RelabelDrop,
+		},
+		nil,
+	}, "keepequal": {
+		config.RelabelConfig{
+			SourceLabels: []string{"__meta_certspotter_id"},
+			TargetLabel:  "__address__",
+			Action:       config.RelabelKeepEqual,
+		},
+		nil,
+	}, "lowercase": {
+		config.RelabelConfig{
+			SourceLabels: []string{"__meta_certspotter_labels_owner"},
+			Separator:    ";",
+			TargetLabel:  "owner",
+			Action:       config.RelabelLowercase,
+		},
+		map[string]string{
+			"__address__":                     "www.example.com",
+			"__meta_certspotter_id":           "648494876",
+			"__meta_certspotter_labels_owner": "Team-A",
+			"owner":                           "team-a",
+		},
+	}, "hashmod": {
+		config.RelabelConfig{
+			SourceLabels: []string{"__address__"},
+			TargetLabel:  "shard",
+			Modulus:      8,
+			Action:       config.RelabelHashMod,
+		},
+		map[string]string{
+			"__address__":                     "www.example.com",
+			"__meta_certspotter_id":           "648494876",
+			"__meta_certspotter_labels_owner": "Team-A",
+			"shard":                           "2",
+		},
+	}, "labelmap": {
+		config.RelabelConfig{
+			Regex:       config.MustNewRegexp(`__meta_certspotter_labels_(.+)`),
+			Replacement: "$1",
+			Action:      config.RelabelLabelMap,
+		},
+		map[string]string{
+			"__address__":                     "www.example.com",
+			"__meta_certspotter_id":           "648494876",
+			"__meta_certspotter_labels_owner": "Team-A",
+			"owner":                           "Team-A",
+		},
+	}, "labeldrop": {
+		config.RelabelConfig{
+			Regex:  config.MustNewRegexp(`__meta_.*`),
+			Action: config.RelabelLabelDrop,
+		},
+		map[string]string{
+			"__address__": "www.example.com",
+		},
+	}, "labelkeep": {
+		config.RelabelConfig{
+			Regex:  config.MustNewRegexp(`__address__|__meta_certspotter_id`),
+			Action: config.RelabelLabelKeep,
+		},
+		map[string]string{
+			"__address__":           "www.example.com",
+			"__meta_certspotter_id": "648494876",
+		},
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		cfg := test.cfg
+		got := Process(labels, &cfg)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got: %v want: %v", got, test.want)
+		}
+	}
+}
@@ -2,40 +2,104 @@ package target
 
 import (
 	"fmt"
+	"net"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"golang.org/x/net/idna"
 
 	"github.com/codecentric/certspotter-sd/internal/certspotter"
+	"github.com/codecentric/certspotter-sd/internal/config"
+	"github.com/codecentric/certspotter-sd/internal/discovery/record"
+	"github.com/codecentric/certspotter-sd/internal/discovery/relabel"
 )
 
+const day = time.Hour * 24
+
+// MatchLabels are the labels without __meta_certspotter_ which targets may
+// have when matched by match_re. Labels of stale_label, zone files and domain
+// or file labels are only set if configured.
+var MatchLabels = matchLabels()
+
+// matchLabels returns the labels of a target for a record with all fields
+// set and days_remaining, which the index sets if configured per file.
+func matchLabels() []string {
+	rec := &record.Record{
+		ID:          "1",
+		DNSNames:    []string{"*.example.com"},
+		IPAddresses: []string{"192.0.2.1"},
+		Emails:      []string{"hostmaster@example.com"},
+		URIs:        []string{"https://example.com"},
+		NotBefore:   time.Unix(0, 0),
+		NotAfter:    time.Unix(0, 0).Add(day),
+		Issuer:      &certspotter.Issuer{Name: "CN=Example CA"},
+		Cert:        &record.Cert{Type: "cert", SHA256: record.NewHash("00")},
+	}
+	tg := NewTarget(rec).Wildcard("*.example.com", nil)
+	tg.AddDomains([]*config.DomainConfig{{Domain: "example.com"}})
+
+	names := []string{"days_remaining"}
+	for label := range tg.Labels {
+		names = append(names, strings.TrimPrefix(label, "__meta_certspotter_"))
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Target represents a prometheus file service discovery target
 type Target struct {
 	Labels  map[string]string `json:"labels"`
 	Targets []string          `json:"targets"`
 }
 
-// NewTarget returns a new target from a certspotter issuance.
-func NewTarget(issuance *certspotter.Issuance) *Target {
+// NewTarget returns a new target from an issuance record. Dns names and ip
+// addresses become addresses, emails and uris are only labels.
+func NewTarget(rec *record.Record) *Target {
 	labels := make(map[string]string)
 
-	labels["__meta_certspotter_id"] = issuance.ID
-	if issuance.Certificate != nil {
-		labels["__meta_certspotter_cert_sha256"] = issuance.Certificate.SHA256
-		labels["__meta_certspotter_cert_type"] = issuance.Certificate.Type
+	labels["__meta_certspotter_id"] = rec.ID
+	if rec.Cert != nil {
+		labels["__meta_certspotter_cert_sha256"] = rec.Cert.SHA256.String()
+		labels["__meta_certspotter_cert_type"] = rec.Cert.Type
+	}
+	if len(rec.DNSNames) != 0 {
+		labels["__meta_certspotter_dns_names"] = strings.Join(rec.DNSNames, ";")
+		labels["__meta_certspotter_dns_names_unicode"] = strings.Join(Unicode(rec.DNSNames), ";")
+	}
+	if len(rec.IPAddresses) != 0 {
+		labels["__meta_certspotter_ip_addresses"] = strings.Join(rec.IPAddresses, ";")
 	}
-	if len(issuance.DNSNames) != 0 {
-		labels["__meta_certspotter_dns_names"] = strings.Join(issuance.DNSNames, ";")
+	if len(rec.Emails) != 0 {
+		labels["__meta_certspotter_emails"] = strings.Join(rec.Emails, ";")
 	}
-	if issuance.Issuer != nil {
-		labels["__meta_certspotter_issuer_name"] = issuance.Issuer.Name
+	if len(rec.URIs) != 0 {
+		labels["__meta_certspotter_uris"] = strings.Join(rec.URIs, ";")
+	}
+	if rec.Issuer != nil {
+		labels["__meta_certspotter_issuer_name"] = rec.Issuer.Name
+	}
+	if !rec.NotBefore.IsZero() {
+		labels["__meta_certspotter_not_before"] = rec.NotBefore.UTC().Format(time.RFC3339)
+		labels["__meta_certspotter_not_before_timestamp"] = strconv.FormatInt(rec.NotBefore.Unix(), 10)
+	}
+	if !rec.NotAfter.IsZero() {
+		labels["__meta_certspotter_not_after"] = rec.NotAfter.UTC().Format(time.RFC3339)
+		labels["__meta_certspotter_not_after_timestamp"] = strconv.FormatInt(rec.NotAfter.Unix(), 10)
+	}
+	if !rec.NotBefore.IsZero() && !rec.NotAfter.IsZero() {
+		labels["__meta_certspotter_lifetime_days"] = strconv.Itoa(LifetimeDays(rec))
 	}
 
 	var targets []string
-	for _, name := range issuance.DNSNames {
+	for _, name := range rec.DNSNames {
 		if !strings.HasPrefix(name, "*.") {
 			targets = append(targets, name)
 		}
 	}
+	targets = append(targets, rec.IPAddresses...)
 
 	return &Target{
 		Labels:  labels,
@@ -43,6 +107,53 @@ func NewTarget(issuance *certspotter.Issuance) *Target {
 	}
 }
 
+// Wildcard returns a copy of target for hosts covered by wildcard name
+// labeled with __meta_certspotter_wildcard.
+func (t *Target) Wildcard(name string, hosts []string) *Target {
+	labels := make(map[string]string, len(t.Labels)+1)
+	for label, val := range t.Labels {
+		labels[label] = val
+	}
+	labels["__meta_certspotter_wildcard"] = name
+	return &Target{Labels: labels, Targets: hosts}
+}
+
+// Parent returns the name a wildcard name or host name is directly below.
+func Parent(name string) string {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
+
+// Unicode returns names converted from A-labels to unicode for display.
+// Names which can't be converted are kept.
+func Unicode(names []string) []string {
+	unicode := make([]string, len(names))
+	for i, name := range names {
+		prefix := ""
+		if strings.HasPrefix(name, "*.") {
+			prefix, name = "*.", name[2:]
+		}
+		if u, err := idna.Display.ToUnicode(name); err == nil {
+			name = u
+		}
+		unicode[i] = prefix + name
+	}
+	return unicode
+}
+
+// LifetimeDays returns the number of days rec is valid. The validity period
+// includes not after, so a 90 day certificate ends at 23:59:59.
+func LifetimeDays(rec *record.Record) int {
+	return int((rec.NotAfter.Sub(rec.NotBefore) + time.Second) / day)
+}
+
+// DaysRemaining returns the number of full days rec is valid after now.
+func DaysRemaining(rec *record.Record, now time.Time) int {
+	return int(rec.NotAfter.Sub(now) / day)
+}
+
 // AddLabels adds labels to target with prefix __meta_certspotter_labels_
 func (t *Target) AddLabels(labels map[string]string) {
 	for name, val := range labels {
@@ -51,6 +162,41 @@ func (t *Target) AddLabels(labels map[string]string) {
 	}
 }
 
+// AddDomains adds the domains an issuance was found for as
+// __meta_certspotter_domain and their labels with prefix
+// __meta_certspotter_labels_. Values of several domains are joined by ";".
+func (t *Target) AddDomains(cfgs []*config.DomainConfig) {
+	names := make(map[string]bool)
+	labels := make(map[string]map[string]bool)
+	for _, cfg := range cfgs {
+		names[cfg.Domain] = true
+		for name, val := range cfg.Labels {
+			if labels[name] == nil {
+				labels[name] = make(map[string]bool)
+			}
+			labels[name][val] = true
+		}
+	}
+
+	if len(names) != 0 {
+		t.Labels["__meta_certspotter_domain"] = join(names)
+	}
+	for name, vals := range labels {
+		label := fmt.Sprintf("__meta_certspotter_labels_%s", name)
+		t.Labels[label] = join(vals)
+	}
+}
+
+// join returns the sorted keys of set joined by ";".
+func join(set map[string]bool) string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ";")
+}
+
 // Matches tests if target labels match map of regex patterns.
 // __meta_certspotter_ is removed from target labels before matching.
 func (t *Target) Matches(matches map[string]*regexp.Regexp) bool {
@@ -62,3 +208,155 @@ func (t *Target) Matches(matches map[string]*regexp.Regexp) bool {
 	}
 	return true
 }
+
+// Key returns a key to sort targets deterministically by issuance id and
+// addresses.
+func (t *Target) Key() string {
+	return t.Labels["__meta_certspotter_id"] + "\x00" + strings.Join(t.Targets, ",")
+}
+
+// TemplateData is the data available to address and label templates.
+type TemplateData struct {
+	// Host of the address after host overrides.
+	Host string
+	// Name is the dns name of the certificate.
+	Name string
+	// Port and Module of the port configuration, if any.
+	Port   int
+	Module string
+	// Labels of the target.
+	Labels map[string]string
+	// Issuance the target was created for.
+	Issuance *record.Record
+}
+
+// Expand returns a target per address and port with host overrides, ports,
+// label templates and address template of cfg applied. The dns name of each
+// target is kept in __meta_certspotter_host. Addresses whose templates fail
+// to render or render empty are dropped and returned as errors. IPv6
+// addresses without port are bracketed.
+func (t *Target) Expand(cfg *config.FileConfig, rec *record.Record) ([]*Target, []error) {
+	if !cfg.PerHost() {
+		return []*Target{t.bracketed()}, nil
+	}
+
+	ports := cfg.Ports
+	if len(ports) == 0 {
+		ports = []*config.PortConfig{nil}
+	}
+
+	var tgs []*Target
+	var errs []error
+	for _, name := range t.Targets {
+		for _, port := range ports {
+			labels := make(map[string]string, len(t.Labels)+3)
+			for label, val := range t.Labels {
+				labels[label] = val
+			}
+			labels["__meta_certspotter_host"] = name
+
+			data := &TemplateData{Host: name, Name: name, Labels: labels, Issuance: rec}
+			if host, ok := cfg.HostOverrides[name]; ok {
+				data.Host = host
+			}
+			if port != nil {
+				data.Port, data.Module = port.Port, port.Module
+				labels["port"] = strconv.Itoa(port.Port)
+				if port.Module != "" {
+					labels["__param_module"] = port.Module
+				}
+			}
+			tg, err := expand(data, cfg)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("rendering templates of host %s: %w", name, err))
+				continue
+			}
+			if tg.Targets[0] == "" {
+				errs = append(errs, fmt.Errorf("address of host %s rendered empty", name))
+				continue
+			}
+			tgs = append(tgs, tg)
+		}
+	}
+	return tgs, errs
+}
+
+// expand renders the target of a single host.
+func expand(data *TemplateData, cfg *config.FileConfig) (*Target, error) {
+	rendered := make(map[string]string, len(cfg.LabelTemplates))
+	for name, tmpl := range cfg.LabelTemplates {
+		val, err := tmpl.Render(data)
+		if err != nil {
+			return nil, err
+		}
+		rendered[fmt.Sprintf("__meta_certspotter_labels_%s", name)] = val
+	}
+
+	addr := address(data.Host)
+	if data.Port != 0 {
+		addr = net.JoinHostPort(data.Host, strconv.Itoa(data.Port))
+	}
+	if cfg.AddressTemplate != nil {
+		var err error
+		if addr, err = cfg.AddressTemplate.Render(data); err != nil {
+			return nil, err
+		}
+	}
+
+	labels := data.Labels
+	for name, val := range rendered {
+		labels[name] = val
+	}
+	return &Target{Labels: labels, Targets: []string{addr}}, nil
+}
+
+// bracketed returns target with IPv6 addresses bracketed or target itself
+// if it has none.
+func (t *Target) bracketed() *Target {
+	var changed bool
+	addrs := make([]string, len(t.Targets))
+	for n, addr := range t.Targets {
+		addrs[n] = address(addr)
+		changed = changed || addrs[n] != addr
+	}
+	if !changed {
+		return t
+	}
+	return &Target{Labels: t.Labels, Targets: addrs}
+}
+
+// address returns host as address without port, IPv6 addresses are
+// bracketed as prometheus requires.
+func address(host string) string {
+	if strings.Contains(host, ":") && net.ParseIP(host) != nil {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// Relabel applies relabel configurations to each address of target with the
+// address as __address__ label. It returns a target per kept address or
+// target itself if there are no configurations.
+func (t *Target) Relabel(cfgs []*config.RelabelConfig) []*Target {
+	if len(cfgs) == 0 {
+		return []*Target{t}
+	}
+
+	var tgs []*Target
+	for _, addr := range t.Targets {
+		labels := make(map[string]string, len(t.Labels)+1)
+		for name, val := range t.Labels {
+			labels[name] = val
+		}
+		labels["__address__"] = addr
+
+		labels = relabel.Process(labels, cfgs...)
+		if labels == nil || labels["__address__"] == "" {
+			continue
+		}
+		addr := labels["__address__"]
+		delete(labels, "__address__")
+		tgs = append(tgs, &Target{Labels: labels, Targets: []string{addr}})
+	}
+	return tgs
+}
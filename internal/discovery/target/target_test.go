@@ -4,10 +4,21 @@ import (
 	"reflect"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/codecentric/certspotter-sd/internal/certspotter"
+	"github.com/codecentric/certspotter-sd/internal/config"
+	"github.com/codecentric/certspotter-sd/internal/discovery/record"
 )
 
+func mustParseTime(str string) time.Time {
+	time, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		panic(err)
+	}
+	return time
+}
+
 func TestNewTarget(t *testing.T) {
 	table := map[string]struct {
 		issuance *certspotter.Issuance
@@ -39,11 +50,26 @@ func TestNewTarget(t *testing.T) {
 		},
 		&Target{
 			Labels: map[string]string{
-				"__meta_certspotter_id":        "648494876",
-				"__meta_certspotter_dns_names": "example.com;example2.com",
+				"__meta_certspotter_id":                "648494876",
+				"__meta_certspotter_dns_names":         "example.com;example2.com",
+				"__meta_certspotter_dns_names_unicode": "example.com;example2.com",
 			},
 			Targets: []string{"example.com", "example2.com"},
 		},
+	}, "ip addresses and other sans": {
+		&certspotter.Issuance{
+			ID:       "648494876",
+			DNSNames: []string{"example.com", "2001:db8::1"},
+		},
+		&Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":                "648494876",
+				"__meta_certspotter_dns_names":         "example.com",
+				"__meta_certspotter_dns_names_unicode": "example.com",
+				"__meta_certspotter_ip_addresses":      "2001:db8::1",
+			},
+			Targets: []string{"example.com", "2001:db8::1"},
+		},
 	}, "only issuer name": {
 		&certspotter.Issuance{
 			ID: "648494876",
@@ -57,6 +83,22 @@ func TestNewTarget(t *testing.T) {
 				"__meta_certspotter_issuer_name": "C=US, O=DigiCert Inc, CN=DigiCert SHA2 Secure Server CA",
 			},
 		},
+	}, "only validity": {
+		&certspotter.Issuance{
+			ID:        "648494876",
+			NotBefore: mustParseTime("2020-01-01T00:00:00Z"),
+			NotAfter:  mustParseTime("2020-03-30T23:59:59Z"),
+		},
+		&Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":                   "648494876",
+				"__meta_certspotter_not_before":           "2020-01-01T00:00:00Z",
+				"__meta_certspotter_not_before_timestamp": "1577836800",
+				"__meta_certspotter_not_after":            "2020-03-30T23:59:59Z",
+				"__meta_certspotter_not_after_timestamp":  "1585612799",
+				"__meta_certspotter_lifetime_days":        "90",
+			},
+		},
 	}, "complete issuance": {
 		&certspotter.Issuance{
 			ID: "648494876",
@@ -71,11 +113,12 @@ func TestNewTarget(t *testing.T) {
 		},
 		&Target{
 			Labels: map[string]string{
-				"__meta_certspotter_id":          "648494876",
-				"__meta_certspotter_cert_sha256": "9250711c54de546f4370e0c3d3a3ec45bc96092a25a4a71a1afa396af7047eb8",
-				"__meta_certspotter_cert_type":   "precert",
-				"__meta_certspotter_dns_names":   "example.com;example2.com",
-				"__meta_certspotter_issuer_name": "C=US, O=DigiCert Inc, CN=DigiCert SHA2 Secure Server CA",
+				"__meta_certspotter_id":                "648494876",
+				"__meta_certspotter_cert_sha256":       "9250711c54de546f4370e0c3d3a3ec45bc96092a25a4a71a1afa396af7047eb8",
+				"__meta_certspotter_cert_type":         "precert",
+				"__meta_certspotter_dns_names":         "example.com;example2.com",
+				"__meta_certspotter_dns_names_unicode": "example.com;example2.com",
+				"__meta_certspotter_issuer_name":       "C=US, O=DigiCert Inc, CN=DigiCert SHA2 Secure Server CA",
 			},
 			Targets: []string{"example.com", "example2.com"},
 		},
@@ -84,13 +127,39 @@ func TestNewTarget(t *testing.T) {
 	for name, test := range table {
 		t.Logf("testing: %s", name)
 
-		got := NewTarget(test.issuance)
+		got := NewTarget(record.New(test.issuance, record.NewInterner()))
 		if !reflect.DeepEqual(got, test.want) {
 			t.Errorf("got: %#v want: %#v", got, test.want)
 		}
 	}
 }
 
+func TestDaysRemaining(t *testing.T) {
+	rec := &record.Record{
+		NotBefore: mustParseTime("2020-01-01T00:00:00Z"),
+		NotAfter:  mustParseTime("2020-03-30T23:59:59Z"),
+	}
+
+	table := map[string]struct {
+		now  time.Time
+		want int
+	}{"issued": {
+		mustParseTime("2020-01-01T00:00:00Z"), 89,
+	}, "last day": {
+		mustParseTime("2020-03-30T00:00:00Z"), 0,
+	}, "day before last day": {
+		mustParseTime("2020-03-29T23:59:59Z"), 1,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		if got := DaysRemaining(rec, test.now); got != test.want {
+			t.Errorf("got: %d want: %d", got, test.want)
+		}
+	}
+}
+
 func TestTargetAddLabels(t *testing.T) {
 	table := map[string]struct {
 		target *Target
@@ -138,6 +207,54 @@ func TestTargetAddLabels(t *testing.T) {
 	}
 }
 
+func TestTargetAddDomains(t *testing.T) {
+	table := map[string]struct {
+		domains []*config.DomainConfig
+		want    map[string]string
+	}{"no domains": {
+		nil,
+		map[string]string{},
+	}, "single domain": {
+		[]*config.DomainConfig{
+			&config.DomainConfig{
+				Domain: "example.com",
+				Labels: map[string]string{"team": "web"},
+			},
+		},
+		map[string]string{
+			"__meta_certspotter_domain":      "example.com",
+			"__meta_certspotter_labels_team": "web",
+		},
+	}, "multiple domains": {
+		[]*config.DomainConfig{
+			&config.DomainConfig{
+				Domain: "shop.example.com",
+				Labels: map[string]string{"team": "shop", "env": "prod"},
+			},
+			&config.DomainConfig{
+				Domain:            "example.com",
+				IncludeSubdomains: true,
+				Labels:            map[string]string{"team": "web", "env": "prod"},
+			},
+		},
+		map[string]string{
+			"__meta_certspotter_domain":      "example.com;shop.example.com",
+			"__meta_certspotter_labels_env":  "prod",
+			"__meta_certspotter_labels_team": "shop;web",
+		},
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		tg := &Target{Labels: make(map[string]string)}
+		tg.AddDomains(test.domains)
+		if !reflect.DeepEqual(tg.Labels, test.want) {
+			t.Errorf("got: %#v want: %#v", tg.Labels, test.want)
+		}
+	}
+}
+
 func TestTargetMatches(t *testing.T) {
 	table := map[string]struct {
 		target  *Target
@@ -192,3 +309,293 @@ func TestTargetMatches(t *testing.T) {
 		}
 	}
 }
+
+func TestTargetKey(t *testing.T) {
+	table := map[string]struct {
+		a, b *Target
+		less bool
+	}{"by id": {
+		&Target{Labels: map[string]string{"__meta_certspotter_id": "1"}},
+		&Target{Labels: map[string]string{"__meta_certspotter_id": "2"}},
+		true,
+	}, "by prefixed id": {
+		&Target{Labels: map[string]string{"__meta_certspotter_id": "12"}},
+		&Target{Labels: map[string]string{"__meta_certspotter_id": "123"}},
+		true,
+	}, "by targets": {
+		&Target{
+			Labels:  map[string]string{"__meta_certspotter_id": "1"},
+			Targets: []string{"b.example.com"},
+		},
+		&Target{
+			Labels:  map[string]string{"__meta_certspotter_id": "1"},
+			Targets: []string{"a.example.com", "b.example.com"},
+		},
+		false,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		got := test.a.Key() < test.b.Key()
+		if got != test.less {
+			t.Errorf("got: %t want: %t", got, test.less)
+		}
+	}
+}
+
+func TestTargetRelabel(t *testing.T) {
+	tg := &Target{
+		Labels:  map[string]string{"__meta_certspotter_id": "648494876"},
+		Targets: []string{"example.com", "www.example.com"},
+	}
+	cfgs := []*config.RelabelConfig{&config.RelabelConfig{
+		SourceLabels: []string{"__address__"},
+		Regex:        config.MustNewRegexp(`www\..*`),
+		Action:       config.RelabelKeep,
+	}}
+
+	got := tg.Relabel(cfgs)
+	want := []*Target{&Target{
+		Labels:  map[string]string{"__meta_certspotter_id": "648494876"},
+		Targets: []string{"www.example.com"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %+v want: %+v", got, want)
+	}
+
+	if got := tg.Relabel(nil); len(got) != 1 || got[0] != tg {
+		t.Errorf("got: %+v want: %+v", got, tg)
+	}
+}
+
+func TestTargetExpand(t *testing.T) {
+	rec := &record.Record{ID: "648494876", DNSNames: []string{"api.example.com", "www.example.com"}}
+	tg := &Target{
+		Labels:  map[string]string{"__meta_certspotter_id": "648494876"},
+		Targets: []string{"api.example.com", "www.example.com"},
+	}
+
+	table := map[string]struct {
+		cfg     *config.FileConfig
+		want    []*Target
+		dropped int
+	}{"no templates": {
+		&config.FileConfig{},
+		[]*Target{tg},
+		0,
+	}, "address template": {
+		&config.FileConfig{AddressTemplate: config.MustNewTemplate("{{.Host}}:443")},
+		[]*Target{&Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":   "648494876",
+				"__meta_certspotter_host": "api.example.com",
+			},
+			Targets: []string{"api.example.com:443"},
+		}, &Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":   "648494876",
+				"__meta_certspotter_host": "www.example.com",
+			},
+			Targets: []string{"www.example.com:443"},
+		}},
+		0,
+	}, "host overrides and label templates": {
+		&config.FileConfig{
+			HostOverrides: map[string]string{"api.example.com": "10.0.0.1"},
+			LabelTemplates: map[string]*config.Template{
+				"server_name": config.MustNewTemplate("{{.Name}}"),
+				"issuance":    config.MustNewTemplate("{{.Issuance.ID}}-{{.Labels.missing}}"),
+			},
+		},
+		[]*Target{&Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":                 "648494876",
+				"__meta_certspotter_host":               "api.example.com",
+				"__meta_certspotter_labels_server_name": "api.example.com",
+				"__meta_certspotter_labels_issuance":    "648494876-",
+			},
+			Targets: []string{"10.0.0.1"},
+		}, &Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":                 "648494876",
+				"__meta_certspotter_host":               "www.example.com",
+				"__meta_certspotter_labels_server_name": "www.example.com",
+				"__meta_certspotter_labels_issuance":    "648494876-",
+			},
+			Targets: []string{"www.example.com"},
+		}},
+		0,
+	}, "ports": {
+		&config.FileConfig{
+			HostOverrides: map[string]string{"api.example.com": "::1"},
+			Ports: []*config.PortConfig{
+				&config.PortConfig{Port: 443, Module: "tls"},
+				&config.PortConfig{Port: 993},
+			},
+		},
+		[]*Target{&Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":   "648494876",
+				"__meta_certspotter_host": "api.example.com",
+				"__param_module":          "tls",
+				"port":                    "443",
+			},
+			Targets: []string{"[::1]:443"},
+		}, &Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":   "648494876",
+				"__meta_certspotter_host": "api.example.com",
+				"port":                    "993",
+			},
+			Targets: []string{"[::1]:993"},
+		}, &Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":   "648494876",
+				"__meta_certspotter_host": "www.example.com",
+				"__param_module":          "tls",
+				"port":                    "443",
+			},
+			Targets: []string{"www.example.com:443"},
+		}, &Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":   "648494876",
+				"__meta_certspotter_host": "www.example.com",
+				"port":                    "993",
+			},
+			Targets: []string{"www.example.com:993"},
+		}},
+		0,
+	}, "ipv6 address template": {
+		&config.FileConfig{
+			AddressTemplate: config.MustNewTemplate(`{{joinHostPort .Host "443"}}`),
+			HostOverrides:   map[string]string{"api.example.com": "2001:db8::1"},
+		},
+		[]*Target{&Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":   "648494876",
+				"__meta_certspotter_host": "api.example.com",
+			},
+			Targets: []string{"[2001:db8::1]:443"},
+		}, &Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":   "648494876",
+				"__meta_certspotter_host": "www.example.com",
+			},
+			Targets: []string{"www.example.com:443"},
+		}},
+		0,
+	}, "empty address": {
+		&config.FileConfig{AddressTemplate: config.MustNewTemplate(`{{if eq .Host "www.example.com"}}{{.Host}}{{end}}`)},
+		[]*Target{&Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":   "648494876",
+				"__meta_certspotter_host": "www.example.com",
+			},
+			Targets: []string{"www.example.com"},
+		}},
+		1,
+	}, "failing template": {
+		&config.FileConfig{AddressTemplate: config.MustNewTemplate(`{{.Issuance.Missing}}`)},
+		nil,
+		2,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		got, errs := tg.Expand(test.cfg, rec)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got: %+v want: %+v", got, test.want)
+		}
+		if len(errs) != test.dropped {
+			t.Errorf("got: %v want: %d dropped targets", errs, test.dropped)
+		}
+	}
+}
+
+func TestTargetExpandIPv6(t *testing.T) {
+	rec := &record.Record{ID: "648494876", IPAddresses: []string{"192.0.2.1", "2001:db8::1"}}
+	tg := &Target{
+		Labels:  map[string]string{"__meta_certspotter_id": "648494876"},
+		Targets: []string{"192.0.2.1", "2001:db8::1"},
+	}
+
+	table := map[string]struct {
+		cfg  *config.FileConfig
+		want []*Target
+	}{"no templates": {
+		&config.FileConfig{IncludeIPSANs: true},
+		[]*Target{&Target{Labels: tg.Labels, Targets: []string{"192.0.2.1", "[2001:db8::1]"}}},
+	}, "host overrides": {
+		&config.FileConfig{IncludeIPSANs: true, HostOverrides: map[string]string{"192.0.2.1": "::1"}},
+		[]*Target{&Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":   "648494876",
+				"__meta_certspotter_host": "192.0.2.1",
+			},
+			Targets: []string{"[::1]"},
+		}, &Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":   "648494876",
+				"__meta_certspotter_host": "2001:db8::1",
+			},
+			Targets: []string{"[2001:db8::1]"},
+		}},
+	}, "ports": {
+		&config.FileConfig{IncludeIPSANs: true, Ports: []*config.PortConfig{&config.PortConfig{Port: 443}}},
+		[]*Target{&Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":   "648494876",
+				"__meta_certspotter_host": "192.0.2.1",
+				"port":                    "443",
+			},
+			Targets: []string{"192.0.2.1:443"},
+		}, &Target{
+			Labels: map[string]string{
+				"__meta_certspotter_id":   "648494876",
+				"__meta_certspotter_host": "2001:db8::1",
+				"port":                    "443",
+			},
+			Targets: []string{"[2001:db8::1]:443"},
+		}},
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		got, errs := tg.Expand(test.cfg, rec)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got: %+v want: %+v", got, test.want)
+		}
+		if len(errs) != 0 {
+			t.Errorf("got: %v want: no dropped targets", errs)
+		}
+	}
+}
+
+func TestParent(t *testing.T) {
+	table := map[string]string{
+		"*.example.com":   "example.com",
+		"www.example.com": "example.com",
+		"example.com":     "com",
+		"localhost":       "",
+	}
+
+	for name, want := range table {
+		t.Logf("testing: %s", name)
+
+		if got := Parent(name); got != want {
+			t.Errorf("got: %s want: %s", got, want)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	names := []string{"xn--bcher-kva.example.de", "*.xn--bcher-kva.example.de", "example.com", "xn--a.example.com"}
+	want := []string{"bücher.example.de", "*.bücher.example.de", "example.com", "xn--a.example.com"}
+
+	if got := Unicode(names); !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v want: %v", got, want)
+	}
+}
@@ -0,0 +1,318 @@
+// Package zone reads the host names of BIND zone files.
+package zone
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// regexTTL matches ttls in seconds or with units, e.g. 3600 or 1h30m.
+	regexTTL = regexp.MustCompile(`^([0-9]+[smhdwSMHDW]?)+$`)
+
+	// classes are the dns classes a record may specify.
+	classes = map[string]bool{"IN": true, "CH": true, "CS": true, "HS": true, "ANY": true}
+
+	// hostTypes are the record types whose owner names are hosts.
+	hostTypes = map[string]bool{"A": true, "AAAA": true, "CNAME": true}
+)
+
+// Zone is the inventory of host names of a zone.
+type Zone struct {
+	// Origin of the zone as declared by its SOA record.
+	Origin string
+	// Hosts of the zone owning A, AAAA or CNAME records.
+	Hosts map[string]bool
+
+	// parents of hosts for matching wildcard names.
+	parents map[string]bool
+}
+
+// ParseFile parses the zone file filename. Relative names before an $ORIGIN
+// directive are relative to the origin derived from filename, see
+// FilenameOrigin.
+func ParseFile(filename string) (*Zone, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	z, err := ParseOrigin(f, FilenameOrigin(filename))
+	if err != nil {
+		return nil, fmt.Errorf("parsing zone file %s: %w", filename, err)
+	}
+	return z, nil
+}
+
+// Parse parses a zone in BIND format. Relative names require an $ORIGIN
+// directive and $INCLUDE or $GENERATE directives are not supported. Host
+// names are returned in lower case without trailing dot.
+func Parse(r io.Reader) (*Zone, error) {
+	return ParseOrigin(r, "")
+}
+
+// ParseOrigin parses a zone in BIND format like Parse, with relative names
+// before an $ORIGIN directive relative to origin.
+func ParseOrigin(r io.Reader, origin string) (*Zone, error) {
+	z := &Zone{Hosts: make(map[string]bool), parents: make(map[string]bool)}
+
+	origin = strings.TrimSuffix(strings.ToLower(origin), ".")
+	var owner string
+	var hosts []string
+	lines := &lines{scanner: bufio.NewScanner(r)}
+	for {
+		tokens, blank, lineno, err := lines.next()
+		if err != nil {
+			return nil, err
+		}
+		if tokens == nil {
+			break
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(tokens[0], "$") && !blank {
+			switch strings.ToUpper(tokens[0]) {
+			case "$ORIGIN":
+				if len(tokens) < 2 {
+					return nil, fmt.Errorf("line %d: $ORIGIN requires a name", lineno)
+				}
+				if origin, err = absolute(tokens[1], origin); err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineno, err)
+				}
+			case "$TTL":
+			default:
+				return nil, fmt.Errorf("line %d: directive %s is not supported", lineno, tokens[0])
+			}
+			continue
+		}
+
+		if !blank {
+			if owner, err = absolute(tokens[0], origin); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineno, err)
+			}
+			tokens = tokens[1:]
+		} else if owner == "" {
+			return nil, fmt.Errorf("line %d: record without owner", lineno)
+		}
+
+		typ := recordType(tokens)
+		switch {
+		case typ == "":
+			return nil, fmt.Errorf("line %d: record without type", lineno)
+		case typ == "SOA":
+			if z.Origin != "" {
+				return nil, fmt.Errorf("line %d: zone must have exactly one SOA record", lineno)
+			}
+			z.Origin = owner
+		case hostTypes[typ]:
+			hosts = append(hosts, owner)
+		}
+	}
+
+	if z.Origin == "" {
+		return nil, fmt.Errorf("zone must have exactly one SOA record")
+	}
+	for _, host := range hosts {
+		if host == z.Origin || strings.HasSuffix(host, "."+z.Origin) {
+			z.Hosts[host] = true
+			z.parents[parent(host)] = true
+		}
+	}
+	return z, nil
+}
+
+// Contains returns if name is a host of zone. Wildcard names are contained
+// if the zone has the wildcard or a host directly below it.
+func (z *Zone) Contains(name string) bool {
+	if z.Hosts[name] {
+		return true
+	}
+	return strings.HasPrefix(name, "*.") && z.parents[parent(name)]
+}
+
+// Inventory holds zones by origin.
+type Inventory struct {
+	zones map[string]*Zone
+}
+
+// NewInventory returns an inventory of zones.
+func NewInventory(zones []*Zone) *Inventory {
+	inv := &Inventory{zones: make(map[string]*Zone, len(zones))}
+	for _, z := range zones {
+		inv.zones[z.Origin] = z
+	}
+	return inv
+}
+
+// Zone returns the zone with the longest origin containing name or nil.
+func (inv *Inventory) Zone(name string) *Zone {
+	for name != "" {
+		if z, ok := inv.zones[name]; ok {
+			return z
+		}
+		name = parent(name)
+	}
+	return nil
+}
+
+// Missing returns if all names are hosts of their zones and the origins of
+// zones which miss names. Names outside of all zones are not in a zone but
+// missing from none.
+func (inv *Inventory) Missing(names []string) (bool, []string) {
+	all := true
+	var missing []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		z := inv.Zone(name)
+		if z == nil {
+			all = false
+			continue
+		}
+		if z.Contains(name) {
+			continue
+		}
+		all = false
+		if !seen[z.Origin] {
+			seen[z.Origin] = true
+			missing = append(missing, z.Origin)
+		}
+	}
+	return all, missing
+}
+
+// recordType returns the type of a record following an optional ttl and
+// class in any order.
+func recordType(tokens []string) string {
+	for _, token := range tokens {
+		upper := strings.ToUpper(token)
+		if classes[upper] || regexTTL.MatchString(token) {
+			continue
+		}
+		return upper
+	}
+	return ""
+}
+
+// FilenameOrigin returns the origin named by a zone file following common
+// conventions, i.e. its base name without a .zone or .db extension or db.
+// prefix, e.g. example.com for zones/example.com.zone or zones/db.example.com.
+func FilenameOrigin(filename string) string {
+	name := strings.ToLower(filepath.Base(filename))
+	for _, ext := range []string{".zone", ".db"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return strings.TrimPrefix(name, "db.")
+}
+
+// absolute returns name relative to origin in lower case without trailing
+// dot.
+func absolute(name, origin string) (string, error) {
+	name = strings.ToLower(name)
+	switch {
+	case name == "@":
+		if origin == "" {
+			return "", fmt.Errorf("@ used without $ORIGIN")
+		}
+		return origin, nil
+	case strings.HasSuffix(name, "."):
+		return strings.TrimSuffix(name, "."), nil
+	case origin == "":
+		return "", fmt.Errorf("relative name %s used without $ORIGIN", name)
+	}
+	return name + "." + origin, nil
+}
+
+// parent returns the name without its first label.
+func parent(name string) string {
+	if i := strings.Index(name, "."); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
+
+// lines reads logical lines of a zone file joining parentheses.
+type lines struct {
+	scanner *bufio.Scanner
+	lineno  int
+}
+
+// next returns the tokens of the next logical line, if it started with a
+// blank owner and its line number. Tokens are nil at the end of input.
+func (l *lines) next() ([]string, bool, int, error) {
+	var tokens []string
+	var blank bool
+	var depth, start int
+	for l.scanner.Scan() {
+		l.lineno++
+		line := l.scanner.Text()
+		if start == 0 {
+			start = l.lineno
+			blank = line != "" && (line[0] == ' ' || line[0] == '\t')
+		}
+
+		quoted := false
+		var token strings.Builder
+		var has bool
+		flush := func() {
+			if has {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				has = false
+			}
+		}
+	scan:
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case c == '\\' && i+1 < len(line):
+				token.WriteByte(c)
+				token.WriteByte(line[i+1])
+				has = true
+				i++
+			case c == '"':
+				quoted = !quoted
+				has = true
+			case quoted:
+				token.WriteByte(c)
+			case c == ';':
+				break scan
+			case c == '(':
+				flush()
+				depth++
+			case c == ')':
+				flush()
+				if depth--; depth < 0 {
+					return nil, false, start, fmt.Errorf("line %d: unbalanced parentheses", l.lineno)
+				}
+			case c == ' ' || c == '\t':
+				flush()
+			default:
+				token.WriteByte(c)
+				has = true
+			}
+		}
+		flush()
+
+		if depth == 0 {
+			if tokens == nil {
+				tokens = []string{}
+			}
+			return tokens, blank, start, nil
+		}
+	}
+	if err := l.scanner.Err(); err != nil {
+		return nil, false, start, err
+	}
+	if depth > 0 {
+		return nil, false, start, fmt.Errorf("line %d: unbalanced parentheses", start)
+	}
+	return nil, false, start, nil
+}
@@ -0,0 +1,182 @@
+package zone
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const example = `$ORIGIN example.com.
+$TTL 3600
+@	IN	SOA	ns1 hostmaster (
+		2020111001 ; serial
+		7200       ; refresh
+		3600 1209600 3600 )
+	IN	NS	ns1
+	IN	A	192.0.2.1
+ns1	IN	A	192.0.2.2
+WWW	300 IN	CNAME	@
+	IN	TXT	"v=spf1 ; -all"
+mail	IN	AAAA	2001:db8::1
+	IN	MX	10 mail
+shop.example.com. IN 1h A 192.0.2.3
+*.apps	A	192.0.2.4
+_dmarc	TXT	"v=DMARC1"
+other.example.org. A 192.0.2.5
+$ORIGIN dev.example.com.
+api	A	192.0.2.6
+`
+
+func TestParse(t *testing.T) {
+	table := map[string]struct {
+		data string
+		want *Zone
+		ok   bool
+	}{"zone": {
+		example,
+		&Zone{Origin: "example.com", Hosts: map[string]bool{
+			"example.com": true, "ns1.example.com": true, "www.example.com": true,
+			"mail.example.com": true, "shop.example.com": true, "*.apps.example.com": true,
+			"api.dev.example.com": true,
+		}},
+		true,
+	}, "absolute names": {
+		"example.net. SOA ns1.example.net. hostmaster.example.net. 1 2 3 4 5\nwww.example.net. A 192.0.2.1\n",
+		&Zone{Origin: "example.net", Hosts: map[string]bool{"www.example.net": true}},
+		true,
+	}, "relative without origin": {
+		"@ SOA ns1 hostmaster 1 2 3 4 5\n",
+		nil,
+		false,
+	}, "missing soa": {
+		"$ORIGIN example.com.\nwww A 192.0.2.1\n",
+		nil,
+		false,
+	}, "multiple soa": {
+		"$ORIGIN example.com.\n@ SOA ns1 hostmaster 1 2 3 4 5\n@ SOA ns1 hostmaster 1 2 3 4 5\n",
+		nil,
+		false,
+	}, "unbalanced parentheses": {
+		"$ORIGIN example.com.\n@ SOA ns1 hostmaster ( 1 2 3 4 5\n",
+		nil,
+		false,
+	}, "include": {
+		"$INCLUDE other.zone\n",
+		nil,
+		false,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		got, err := Parse(strings.NewReader(test.data))
+		if (err == nil) != test.ok {
+			t.Errorf("got: %v want ok: %t", err, test.ok)
+		}
+		if !test.ok {
+			continue
+		}
+		if got.Origin != test.want.Origin || !reflect.DeepEqual(got.Hosts, test.want.Hosts) {
+			t.Errorf("got: %s %v want: %s %v", got.Origin, got.Hosts, test.want.Origin, test.want.Hosts)
+		}
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	table := map[string]struct {
+		filename string
+		data     string
+		want     *Zone
+		ok       bool
+	}{"origin from filename": {
+		"example.com.zone",
+		"@ SOA ns1 hostmaster 1 2 3 4 5\nwww A 192.0.2.1\n",
+		&Zone{Origin: "example.com", Hosts: map[string]bool{"www.example.com": true}},
+		true,
+	}, "origin from db prefix": {
+		"db.example.org",
+		"@ SOA ns1 hostmaster 1 2 3 4 5\nwww A 192.0.2.1\n",
+		&Zone{Origin: "example.org", Hosts: map[string]bool{"www.example.org": true}},
+		true,
+	}, "origin directive precedes filename": {
+		"other.zone",
+		"$ORIGIN example.net.\n@ SOA ns1 hostmaster 1 2 3 4 5\nwww A 192.0.2.1\n",
+		&Zone{Origin: "example.net", Hosts: map[string]bool{"www.example.net": true}},
+		true,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		filename := filepath.Join(dir, test.filename)
+		if err := ioutil.WriteFile(filename, []byte(test.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ParseFile(filename)
+		if (err == nil) != test.ok {
+			t.Errorf("got: %v want ok: %t", err, test.ok)
+		}
+		if !test.ok {
+			continue
+		}
+		if got.Origin != test.want.Origin || !reflect.DeepEqual(got.Hosts, test.want.Hosts) {
+			t.Errorf("got: %s %v want: %s %v", got.Origin, got.Hosts, test.want.Origin, test.want.Hosts)
+		}
+	}
+}
+
+func TestInventoryMissing(t *testing.T) {
+	com, err := Parse(strings.NewReader(example))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := Parse(strings.NewReader("$ORIGIN dev.example.com.\n@ SOA ns1 hostmaster 1 2 3 4 5\nweb A 192.0.2.1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv := NewInventory([]*Zone{com, dev})
+
+	table := map[string]struct {
+		names   []string
+		all     bool
+		missing []string
+	}{"in zone": {
+		[]string{"example.com", "www.example.com"},
+		true,
+		nil,
+	}, "wildcard": {
+		[]string{"*.example.com", "*.apps.example.com"},
+		true,
+		nil,
+	}, "missing": {
+		[]string{"www.example.com", "legacy.example.com"},
+		false,
+		[]string{"example.com"},
+	}, "longest origin": {
+		[]string{"web.dev.example.com", "api.dev.example.com"},
+		false,
+		[]string{"dev.example.com"},
+	}, "outside zones": {
+		[]string{"www.example.org"},
+		false,
+		nil,
+	}}
+
+	for name, test := range table {
+		t.Logf("testing: %s", name)
+
+		all, missing := inv.Missing(test.names)
+		if all != test.all || !reflect.DeepEqual(missing, test.missing) {
+			t.Errorf("got: %t %v want: %t %v", all, missing, test.all, test.missing)
+		}
+	}
+}